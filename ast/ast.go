@@ -9,6 +9,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos 节点在源码中的位置，即节点对应的词法单元的位置
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -33,6 +35,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -42,7 +51,7 @@ func (p *Program) String() string {
 }
 
 type LetStatement struct {
-	// the `let` token
+	// the `let` or `const` token
 	Token token.Token
 	// the name of ident
 	Name *Identifier
@@ -54,6 +63,11 @@ func (ls *LetStatement) statementNode() {}
 
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
+// IsConst 是否为const语句，常量不能被重新定义或赋值
+func (ls *LetStatement) IsConst() bool { return ls.Token.Type == token.CONST }
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -82,6 +96,8 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -95,6 +111,132 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement 形如"while (cond) { ... }"
+type WhileStatement struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Pos }
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement 形如"for (x in arr) { ... }"，依次将数组的每个元素绑定到Variable
+type ForStatement struct {
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+
+func (fs *ForStatement) Pos() token.Position { return fs.Token.Pos }
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BreakStatement) String() string { return "break;" }
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
+func (cs *ContinueStatement) String() string { return "continue;" }
+
+// ThrowStatement 形如"throw expr;"，抛出一个错误
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+
+func (ts *ThrowStatement) Pos() token.Position { return ts.Token.Pos }
+
+func (ts *ThrowStatement) String() string {
+	return "throw " + ts.Value.String() + ";"
+}
+
+// TryStatement 形如"try { ... } catch (e) { ... } finally { ... }"
+// Catch与Finally至少有一个，没有catch时Param与Catch为nil
+type TryStatement struct {
+	Token   token.Token
+	Block   *BlockStatement
+	Param   *Identifier
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (ts *TryStatement) statementNode() {}
+
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+
+func (ts *TryStatement) Pos() token.Position { return ts.Token.Pos }
+
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(ts.Block.String())
+	if ts.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(ts.Param.String())
+		out.WriteString(") ")
+		out.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(ts.Finally.String())
+	}
+
+	return out.String()
+}
+
 type ExpressionStatement struct {
 	Token token.Token
 	Exp   Expression
@@ -104,6 +246,8 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Pos }
+
 func (es *ExpressionStatement) statementNode() {}
 
 func (es *ExpressionStatement) String() string {
@@ -123,6 +267,8 @@ func (bs *BlockStatement) statementNode() {}
 
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -144,6 +290,8 @@ func (i *Identifier) expressionNode() {}
 
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
 func (i *Identifier) String() string { return i.Value }
 
 type IntegerLiteral struct {
@@ -155,8 +303,23 @@ func (i *IntegerLiteral) expressionNode() {}
 
 func (i *IntegerLiteral) TokenLiteral() string { return i.Token.Literal }
 
+func (i *IntegerLiteral) Pos() token.Position { return i.Token.Pos }
+
 func (i *IntegerLiteral) String() string { return i.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode() {}
+
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+
+func (f *FloatLiteral) Pos() token.Position { return f.Token.Pos }
+
+func (f *FloatLiteral) String() string { return f.Token.Literal }
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -166,6 +329,8 @@ func (b *Boolean) expressionNode() {}
 
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
 func (b *Boolean) String() string { return b.Token.Literal }
 
 type StringLiteral struct {
@@ -177,6 +342,8 @@ func (s *StringLiteral) expressionNode() {}
 
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 
+func (s *StringLiteral) Pos() token.Position { return s.Token.Pos }
+
 func (s *StringLiteral) String() string { return s.Token.Literal }
 
 type ArrayLiteral struct {
@@ -188,6 +355,8 @@ func (al *ArrayLiteral) expressionNode() {}
 
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	var elements []string
@@ -212,6 +381,8 @@ func (hl *HashLiteral) expressionNode() {}
 
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	var pairs []string
@@ -237,6 +408,8 @@ func (pe *PrefixExpression) expressionNode() {}
 
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
 
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -259,6 +432,8 @@ func (ie *InfixExpression) expressionNode() {}
 
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *InfixExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -271,6 +446,33 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression 形如"x = v"、"x += v"或"arr[i] = v"，值为赋值后的值
+// Target只能是*Identifier或*IndexExpression
+type AssignExpression struct {
+	Token    token.Token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+
+func (ae *AssignExpression) Pos() token.Position { return ae.Target.Pos() }
+
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type IfExpression struct {
 	Token       token.Token
 	Condition   Expression
@@ -282,6 +484,8 @@ func (ie *IfExpression) expressionNode() {}
 
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -301,12 +505,16 @@ type FunctionLiteral struct {
 	Token      token.Token // "fn"
 	Parameters []*Identifier
 	Body       *BlockStatement
+	// Name 当函数字面量直接绑定到let语句时，记录被绑定的名称，用于编译递归函数
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
 
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -335,6 +543,8 @@ func (ce *CallExpression) expressionNode() {}
 
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+func (ce *CallExpression) Pos() token.Position { return ce.Token.Pos }
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	var args []string
@@ -360,6 +570,8 @@ func (ie *IndexExpression) expressionNode() {}
 
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *IndexExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -371,3 +583,84 @@ func (ie *IndexExpression) String() string {
 
 	return out.String()
 }
+
+// Inspect 深度优先遍历以node为根的语法树，对每个节点调用f
+// f返回false时不再遍历该节点的子节点
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+	// 可以省略的子节点为nil时跳过
+	inspectBlock := func(block *BlockStatement) {
+		if block != nil {
+			Inspect(block, f)
+		}
+	}
+	switch node := node.(type) {
+	case *Program:
+		for _, s := range node.Statements {
+			Inspect(s, f)
+		}
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, f)
+	case *ThrowStatement:
+		Inspect(node.Value, f)
+	case *ExpressionStatement:
+		Inspect(node.Exp, f)
+	case *WhileStatement:
+		Inspect(node.Condition, f)
+		inspectBlock(node.Body)
+	case *ForStatement:
+		Inspect(node.Variable, f)
+		Inspect(node.Iterable, f)
+		inspectBlock(node.Body)
+	case *TryStatement:
+		inspectBlock(node.Block)
+		if node.Param != nil {
+			Inspect(node.Param, f)
+		}
+		inspectBlock(node.Catch)
+		inspectBlock(node.Finally)
+	case *PrefixExpression:
+		Inspect(node.Right, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *AssignExpression:
+		Inspect(node.Target, f)
+		Inspect(node.Value, f)
+	case *IfExpression:
+		Inspect(node.Condition, f)
+		inspectBlock(node.Consequence)
+		inspectBlock(node.Alternative)
+	case *FunctionLiteral:
+		for _, p := range node.Parameters {
+			Inspect(p, f)
+		}
+		inspectBlock(node.Body)
+	case *CallExpression:
+		Inspect(node.Function, f)
+		for _, arg := range node.Arguments {
+			Inspect(arg, f)
+		}
+	case *ArrayLiteral:
+		for _, el := range node.Elements {
+			Inspect(el, f)
+		}
+	case *HashLiteral:
+		for k, v := range node.Pairs {
+			Inspect(k, f)
+			Inspect(v, f)
+		}
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	}
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"monkey_cc/token"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +27,48 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() is wrong:\nexpect: %s\nfound:%s\n", expect, program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: *token.New(token.IDENT, name), Value: name}
+	}
+	// if (a) { b } 没有else分支
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{Exp: &IfExpression{
+				Condition: ident("a"),
+				Consequence: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Exp: &FunctionLiteral{
+						Parameters: []*Identifier{ident("c")},
+						Body:       &BlockStatement{Statements: []Statement{&ExpressionStatement{Exp: ident("d")}}},
+					}},
+					&ExpressionStatement{Exp: ident("b")},
+				}},
+			}},
+		},
+	}
+
+	var names []string
+	Inspect(program, func(node Node) bool {
+		if id, ok := node.(*Identifier); ok {
+			names = append(names, id.Value)
+		}
+		return true
+	})
+	if strings.Join(names, " ") != "a c d b" {
+		t.Errorf("expected identifiers a c d b, found %v", names)
+	}
+
+	// 返回false时跳过子节点
+	names = nil
+	Inspect(program, func(node Node) bool {
+		if id, ok := node.(*Identifier); ok {
+			names = append(names, id.Value)
+		}
+		_, isFn := node.(*FunctionLiteral)
+		return !isFn
+	})
+	if strings.Join(names, " ") != "a b" {
+		t.Errorf("expected identifiers a b, found %v", names)
+	}
+}
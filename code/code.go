@@ -32,6 +32,8 @@ func (ins Instructions) fmt(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 	return fmt.Sprintf("ERROR: unmatched operand numbers for %s to be %d", def.Name, len(def.OperandWidths))
 }
@@ -57,8 +59,46 @@ const (
 	OpJumpNotTruthy // 栈顶为False时跳转
 	OpSetGlobal
 	OpGetGlobal
+	OpArray          // 数组操作码，操作数表示数组元素个数，元素从栈顶取出
+	OpHash           // 哈希操作码，操作数表示栈中键与值的总个数
+	OpIndex          // 索引操作码，栈顶为索引，次栈顶为被索引对象
+	OpCall           // 调用操作码，操作数表示参数个数，被调用函数位于参数之下
+	OpReturnValue    // 从函数返回，返回值为栈顶元素
+	OpReturn         // 从函数返回，返回值为空值
+	OpGetLocal       // 读取局部变量，操作数为局部变量的索引
+	OpSetLocal       // 设置局部变量，操作数为局部变量的索引
+	OpClosure        // 构造闭包，操作数为函数在常量池中的位置与自由变量个数
+	OpGetFree        // 读取自由变量，操作数为自由变量的索引
+	OpCurrentClosure // 将当前执行的闭包压栈，用于递归调用
+	OpGetBuiltin     // 读取内置函数，操作数为内置函数在object.Builtins中的索引
+	OpBitAnd         // 按位与，将栈顶两个整数取出，将结果压栈
+	OpBitOr          // 按位或，将栈顶两个整数取出，将结果压栈
+	OpBitXor         // 按位异或
+	OpBitNot         // 栈顶整数按位取反
+	OpShl            // 左移，右值为移动的位数
+	OpShr            // 算术右移，右值为移动的位数
+	OpMod            // 取余，结果的符号与左值相同
+	OpLessEqual      // 用于比较栈顶两个元素中，左值是否小于等于右值，将结果压栈
+	OpSetFree        // 设置当前闭包的自由变量，操作数为自由变量的索引
+	OpSetIndex       // 索引赋值，栈中依次为被索引对象、索引与值，将值压栈
+	OpThrow          // 将栈顶元素作为错误抛出，由异常表中的处理代码捕获
+	OpDefineLocal    // 定义局部变量，操作数为局部变量的索引，每次执行都产生新的绑定，不影响此前被闭包捕获的变量
+	OpCaptureLocal   // 将局部变量装入共享单元并压栈，供OpClosure捕获，操作数为局部变量的索引
+	OpCaptureFree    // 将当前闭包的自由变量所在的共享单元压栈，供OpClosure捕获，操作数为自由变量的索引
+	OpGreater        // 用于比较栈顶两个元素中，左值是否大于右值，将结果压栈
+	OpGreaterEqual   // 用于比较栈顶两个元素中，左值是否大于等于右值，将结果压栈
+	OpIterable       // 检查栈顶元素能否被for-in遍历，不能遍历时产生运行时错误
 )
 
+// Handler 异常表中的一项，地址在[Start, End)中的指令出错时跳转至Target
+// Depth为进入try语句时操作数栈中局部变量之上的元素个数，跳转前将栈恢复到该深度，并压入被捕获的异常
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
 type Definition struct {
 	Name          string
 	OperandWidths []int
@@ -80,6 +120,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 1:
+			instruction[offset] = byte(o)
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 4:
@@ -93,24 +135,53 @@ func Make(op Opcode, operands ...int) []byte {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}},
-	OpAdd:           {"OpAdd", []int{}},
-	OpSub:           {"OpSub", []int{}},
-	OpMul:           {"OpMul", []int{}},
-	OpDiv:           {"OpDiv", []int{}},
-	OpPop:           {"OpPop", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpNull:          {"OpNull", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpLess:          {"OpLess", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpJump:          {"OpJump", []int{2}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpConstant:       {"OpConstant", []int{2}},
+	OpAdd:            {"OpAdd", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpPop:            {"OpPop", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpNull:           {"OpNull", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpLess:           {"OpLess", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpJump:           {"OpJump", []int{2}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpBitNot:         {"OpBitNot", []int{}},
+	OpShl:            {"OpShl", []int{}},
+	OpShr:            {"OpShr", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpLessEqual:      {"OpLessEqual", []int{}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpDefineLocal:    {"OpDefineLocal", []int{1}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
+	OpGreater:        {"OpGreater", []int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpIterable:       {"OpIterable", []int{}},
 }
 
 // 查找对应操作码的定义
@@ -130,10 +201,20 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	offset := 0
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		case 2:
-			operands[i] = int(binary.BigEndian.Uint16(ins[offset:]))
+			operands[i] = int(ReadUint16(ins[offset:]))
 		}
 		offset += width
 	}
 	return operands, offset
 }
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
@@ -18,6 +18,8 @@ func TestMake(t *testing.T) {
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpBang, []int{}, []byte{byte(OpBang)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 	}
 	for _, tt := range tests {
 		instructions := Make(tt.op, tt.operands...)
@@ -39,12 +41,16 @@ func TestInsString(t *testing.T) {
 		Make(OpConstant, 65535),
 		Make(OpAdd),
 		Make(OpMinus),
+		Make(OpGetLocal, 1),
+		Make(OpClosure, 65535, 255),
 	}
 	expected := `0000 OpConstant 1
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpAdd
 0010 OpMinus
+0011 OpGetLocal 1
+0013 OpClosure 65535 255
 `
 	concatted := Instructions{}
 	for _, ins := range instructions {
@@ -1,41 +1,138 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"monkey_cc/ast"
 	"monkey_cc/code"
 	"monkey_cc/object"
+	"monkey_cc/token"
+	"sort"
 )
 
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+	BuiltinScope  SymbolScope = "BUILTIN"
 )
 
 type Symbol struct {
 	Name  string
 	Scope SymbolScope
 	Index int
+	Const bool // 用const定义，不能被重新定义或赋值
 }
 
+// SymbolTable 符号表，每个函数体与语句块对应一个嵌套的符号表
+// Outer为nil时表示全局符号表
 type SymbolTable struct {
-	store map[string]Symbol
+	Outer *SymbolTable
+	// FreeSymbols 当前函数捕获的外层局部变量，按捕获顺序排列
+	FreeSymbols []Symbol
+
+	store          map[string]Symbol
+	numDefinitions int
+	// 语句块的符号表只限定名称的可见范围，变量的存储位置由所在函数的符号表分配
+	block bool
+}
+
+// 分配存储位置的符号表，即最内层的函数符号表或全局符号表
+func (s *SymbolTable) frame() *SymbolTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
 }
 
 func (s *SymbolTable) NumDefinitions() int {
-	return len(s.store)
+	return s.frame().numDefinitions
+}
+
+// 在函数符号表或全局符号表中分配一个新的存储位置
+func (s *SymbolTable) allocate() Symbol {
+	frame := s.frame()
+	symbol := Symbol{Index: frame.numDefinitions}
+	if frame.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+	frame.numDefinitions++
+	return symbol
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Scope: GlobalScope, Index: s.NumDefinitions()}
+	symbol := s.allocate()
+	symbol.Name = name
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineConst 定义常量
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Const = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// 当前符号表中是否已定义同名常量，外层作用域中的常量可以被遮蔽
+func (s *SymbolTable) definesConst(name string) bool {
+	symbol, ok := s.store[name]
+	return ok && symbol.Const && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope)
+}
+
+// DefineTemp 定义一个没有名称的局部变量，供编译器保存中间值
+func (s *SymbolTable) DefineTemp() Symbol {
+	return s.allocate()
+}
+
+// DefineBuiltin 定义内置函数，index为其在object.Builtins中的位置
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
 	s.store[name] = symbol
 	return symbol
 }
 
+// DefineFunctionName 定义当前函数自身的名称，使函数体可以递归引用自身
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: FunctionScope, Index: 0}
+	s.store[name] = symbol
+	return symbol
+}
+
+// 将外层的局部变量记录为当前函数的自由变量
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1, Const: original.Const}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve 在当前符号表中查找符号，找不到时向外层查找
+// 外层函数中的局部变量会被记录为自由变量，外层语句块与当前语句块属于同一个函数，无需捕获
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
-	return obj, ok
+	if ok || s.Outer == nil {
+		return obj, ok
+	}
+	obj, ok = s.Outer.Resolve(name)
+	if !ok || s.block || obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+		return obj, ok
+	}
+	return s.defineFree(obj), true
+}
+
+// 复合赋值对应的运算
+var compoundOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
 }
 
 type EmittedInstruction struct {
@@ -43,12 +140,49 @@ type EmittedInstruction struct {
 	Position int
 }
 
-type Compiler struct {
-	instructions        []byte
-	constants           []object.Object
+// 正在编译的循环，记录break与continue生成的跳转，在循环编译完成后回填目标地址
+type loopContext struct {
+	breakJumps    []int
+	continueJumps []int
+	// 进入循环时已有的try语句个数，break与continue离开循环中的try语句时需要执行其finally块
+	tries int
+	// 进入循环时的操作数栈深度，break与continue可能出现在表达式中，跳转前需弹出表达式已压栈的值
+	depth int
+}
+
+// 正在编译的受保护区域，即try块或带有finally的catch块
+// return、break与continue离开受保护区域时在跳转前内联finally块，内联的代码不受该区域保护，
+// 因此一个区域在异常表中可能对应多个区间
+type tryContext struct {
+	finally  *ast.BlockStatement // 离开区域时执行的finally块，可能为nil
+	start    int                 // 当前区间的起始地址，-1表示区间已关闭
+	depth    int                 // 进入区域时的操作数栈深度
+	handlers []int               // 区域在异常表中的各个区间，待回填处理代码的地址
+}
+
+// CompilationScope 每个函数体在独立的作用域中编译，拥有自己的指令流
+type CompilationScope struct {
+	instructions        code.Instructions
 	lastInstruction     EmittedInstruction // 前一个表达式
 	previousInstruction EmittedInstruction // 前两个表达式，仅在回退时使用
-	symbolTable         *SymbolTable
+	loops               []*loopContext     // 由外向内嵌套的循环
+	tries               []*tryContext      // 由外向内嵌套的受保护区域
+	handlers            []code.Handler     // 异常表
+	// 执行到当前位置时操作数栈中局部变量之上的元素个数，由生成的指令推算
+	depth int
+	// 指令的起始地址到源码位置的映射，用于在运行时错误中报告位置
+	positions map[int]token.Position
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// 正在编译的节点的位置，生成的指令映射到该位置
+	position token.Position
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -56,17 +190,110 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s}
 }
 
+// Clone 复制全局符号表，REPL在编译失败时丢弃副本即可保留原有的定义
+func (s *SymbolTable) Clone() *SymbolTable {
+	clone := NewSymbolTable()
+	for name, symbol := range s.store {
+		clone.store[name] = symbol
+	}
+	clone.numDefinitions = s.numDefinitions
+	return clone
+}
+
+// Rollback 撤销未生效的全局变量定义，恢复为old中的同名定义
+// 执行失败时，失败位置之后的let语句虽已在符号表中定义，却从未写入变量
+func (s *SymbolTable) Rollback(old *SymbolTable, defined func(Symbol) bool) {
+	for name, symbol := range s.store {
+		if symbol.Scope != GlobalScope || defined(symbol) {
+			continue
+		}
+		if original, ok := old.store[name]; ok {
+			s.store[name] = original
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable 语句块的符号表，块中定义的变量在块外不可见
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
 func New() *Compiler {
-	return &Compiler{
-		instructions:        []byte{},
-		constants:           []object.Object{},
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
-		symbolTable:         NewSymbolTable(),
+		positions:           map[int]token.Position{},
+	}
+	symbolTable := NewSymbolTable()
+	for i, def := range object.Builtins {
+		symbolTable.DefineBuiltin(i, def.Name)
+	}
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
 	}
 }
 
+// NewWithState 使用已有的符号表与常量池创建编译器
+// 用于REPL中在多次输入之间保留全局变量的定义
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// 单字节操作数所能表示的最大值，局部变量与自由变量的索引以及调用的参数个数都不能超过该值
+const maxByteOperand = 1<<8 - 1
+
+// Error 编译错误，Position为出错的源码位置，未知时为零值
+type Error struct {
+	Position token.Position
+	Message  string
+}
+
+func (e *Error) Error() string {
+	if !e.Position.IsValid() {
+		return e.Message
+	}
+	return e.Position.String() + ": " + e.Message
+}
+
+// Compile 编译语法树中的节点，产生的错误没有位置时使用出错的最内层节点的位置
 func (c *Compiler) Compile(node ast.Node) error {
+	// 解析出错的语法树中可能缺少节点，调用者应先检查解析错误
+	if node == nil {
+		return errors.New("incomplete syntax tree, the program has parse errors")
+	}
+	// 子节点编译完成后恢复为当前节点的位置
+	outer := c.position
+	c.position = node.Pos()
+	defer func() { c.position = outer }()
+
+	err := c.compile(node)
+	if err == nil {
+		return nil
+	}
+	if compileErr, ok := err.(*Error); ok {
+		return compileErr
+	}
+	return &Error{Position: c.position, Message: err.Error()}
+}
+
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -76,19 +303,66 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.BlockStatement:
+		c.enterBlock()
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
 		}
+		c.leaveBlock()
 	case *ast.LetStatement:
+		if c.symbolTable.definesConst(node.Name.Value) {
+			return &Error{Position: node.Name.Pos(), Message: "cannot redefine constant " + node.Name.Value}
+		}
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && assignsTo(fn.Body, fn.Name) {
+			return c.compileSelfAssigningLet(node)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emitOp(code.OpSetGlobal, symbol.Index)
+		c.defineSymbol(c.defineLet(node))
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+	case *ast.ForStatement:
+		return c.compileFor(node)
+	case *ast.TryStatement:
+		return c.compileTry(node)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emitOp(code.OpThrow)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("break outside of a loop")
+		}
+		if err := c.leaveTries(loop.tries); err != nil {
+			return err
+		}
+		loop.breakJumps = append(loop.breakJumps, c.emitLoopJump(loop))
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("continue outside of a loop")
+		}
+		if err := c.leaveTries(loop.tries); err != nil {
+			return err
+		}
+		loop.continueJumps = append(loop.continueJumps, c.emitLoopJump(loop))
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		// 返回值留在栈中，finally块中的语句不改变栈的深度
+		if err := c.leaveTries(0); err != nil {
+			return err
+		}
+		c.emitOp(code.OpReturnValue)
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Exp)
 		if err != nil {
@@ -96,17 +370,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emitOp(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == ">" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			c.emitOp(code.OpLess)
-			return nil
+		switch node.Operator {
+		case "&&":
+			return c.compileAnd(node)
+		case "||":
+			return c.compileOr(node)
 		}
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -125,28 +393,50 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emitOp(code.OpMul)
 		case "/":
 			c.emitOp(code.OpDiv)
+		case "%":
+			c.emitOp(code.OpMod)
 		case "<":
 			c.emitOp(code.OpLess)
+		case "<=":
+			c.emitOp(code.OpLessEqual)
+		case ">":
+			c.emitOp(code.OpGreater)
+		case ">=":
+			c.emitOp(code.OpGreaterEqual)
 		case "==":
 			c.emitOp(code.OpEqual)
 		case "!=":
 			c.emitOp(code.OpNotEqual)
+		case "&":
+			c.emitOp(code.OpBitAnd)
+		case "|":
+			c.emitOp(code.OpBitOr)
+		case "^":
+			c.emitOp(code.OpBitXor)
+		case "<<":
+			c.emitOp(code.OpShl)
+		case ">>":
+			c.emitOp(code.OpShr)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
-			return nil
+			return err
 		}
 		switch node.Operator {
 		case "-":
 			c.emitOp(code.OpMinus)
 		case "!":
 			c.emitOp(code.OpBang)
+		case "~":
+			c.emitOp(code.OpBitNot)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
 	case *ast.IfExpression:
 		err := c.Compile(node.Condition)
 		if err != nil {
@@ -154,18 +444,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		// emit jnt，使用9999占位
 		jumpNotTruthyPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+		depth := c.scopes[c.scopeIndex].depth
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
 		}
-		if c.lastInstruction.Opcode == code.OpPop {
+		if c.lastInstructionIs(code.OpPop) {
 			// 如果If中表达式块的最后末尾有Pop，则移除这个Pop
 			// 这是为了使得If中表达式块的数值留在栈中
 			c.removeLastPop()
+		} else {
+			// 表达式块为空或以let语句结尾时，其值为空值
+			c.emitOp(code.OpNull)
 		}
 		jumpPos := c.emitOp(code.OpJump, 9999)
-		afterConsequencePos := len(c.instructions)
+		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		// 两个分支从同一深度开始执行
+		c.scopes[c.scopeIndex].depth = depth
 		if node.Alternative == nil {
 			c.emitOp(code.OpNull)
 		} else {
@@ -173,15 +469,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			if c.lastInstruction.Opcode == code.OpPop {
+			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emitOp(code.OpNull)
 			}
 		}
-		afterAlternativePos := len(c.instructions)
+		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 	case *ast.IntegerLiteral: // 对于整型常量值，转化为*object.Integer并保存在常量池中
 		integer := &object.Integer{Value: node.Value}
 		c.emitOp(code.OpConstant, c.pushConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emitOp(code.OpConstant, c.pushConstant(float))
+	case *ast.StringLiteral: // 字符串常量同样保存在常量池中
+		str := &object.String{Value: node.Value}
+		c.emitOp(code.OpConstant, c.pushConstant(str))
 	case *ast.Boolean:
 		if node.Value {
 			c.emitOp(code.OpTrue)
@@ -191,13 +495,626 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("identifier not found: %s", node.Value)
+		}
+		c.loadSymbol(symbol)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+		c.emitOp(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		// map的遍历顺序不确定，按照键的字符串形式排序以保证生成的指令稳定
+		var keys []ast.Expression
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+		c.emitOp(code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emitOp(code.OpIndex)
+	case *ast.FunctionLiteral:
+		c.enterScope()
+		if node.Name != "" && !assignsTo(node.Body, node.Name) {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		// 函数体最后一个表达式的值作为隐式返回值
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emitOp(code.OpReturn)
+		}
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.NumDefinitions()
+		if numLocals > maxByteOperand+1 {
+			return fmt.Errorf("too many local variables in function: %d, at most %d", numLocals, maxByteOperand+1)
+		}
+		if len(freeSymbols) > maxByteOperand {
+			return fmt.Errorf("too many captured variables in function: %d, at most %d", len(freeSymbols), maxByteOperand)
+		}
+		positions := c.scopes[c.scopeIndex].positions
+		handlers := c.scopes[c.scopeIndex].handlers
+		instructions := c.leaveScope()
+		// 在外层作用域中将被捕获的变量所在的共享单元压栈，由OpClosure收集
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Positions:     positions,
+			Handlers:      handlers,
+		}
+		c.emitOp(code.OpClosure, c.pushConstant(compiledFn), len(freeSymbols))
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+		if len(node.Arguments) > maxByteOperand {
+			return fmt.Errorf("too many arguments in call: %d, at most %d", len(node.Arguments), maxByteOperand)
+		}
+		for _, arg := range node.Arguments {
+			err := c.Compile(arg)
+			if err != nil {
+				return err
+			}
 		}
-		c.emitOp(code.OpGetGlobal, symbol.Index)
+		c.emitOp(code.OpCall, len(node.Arguments))
 	}
 	return nil
 }
 
+// 短路与：左值为假时不再对右值求值，结果为布尔值
+//
+//	<left>  OpJumpNotTruthy false
+//	<right> OpJumpNotTruthy false
+//	OpTrue  OpJump end
+//	false: OpFalse
+//	end:
+func (c *Compiler) compileAnd(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	leftJumpPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+	depth := c.scopes[c.scopeIndex].depth
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	rightJumpPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+	c.emitOp(code.OpTrue)
+	endJumpPos := c.emitOp(code.OpJump, 9999)
+	c.scopes[c.scopeIndex].depth = depth
+	falsePos := len(c.currentInstructions())
+	c.changeOperand(leftJumpPos, falsePos)
+	c.changeOperand(rightJumpPos, falsePos)
+	c.emitOp(code.OpFalse)
+	c.changeOperand(endJumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// 短路或：左值为真时不再对右值求值，结果为布尔值
+//
+//	<left>  OpJumpNotTruthy right
+//	OpTrue  OpJump end
+//	right: <right> OpJumpNotTruthy false
+//	OpTrue  OpJump end
+//	false: OpFalse
+//	end:
+func (c *Compiler) compileOr(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	leftJumpPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+	depth := c.scopes[c.scopeIndex].depth
+	c.emitOp(code.OpTrue)
+	leftEndJumpPos := c.emitOp(code.OpJump, 9999)
+	c.scopes[c.scopeIndex].depth = depth
+	c.changeOperand(leftJumpPos, len(c.currentInstructions()))
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	rightJumpPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+	c.emitOp(code.OpTrue)
+	rightEndJumpPos := c.emitOp(code.OpJump, 9999)
+	c.scopes[c.scopeIndex].depth = depth
+	c.changeOperand(rightJumpPos, len(c.currentInstructions()))
+	c.emitOp(code.OpFalse)
+	endPos := len(c.currentInstructions())
+	c.changeOperand(leftEndJumpPos, endPos)
+	c.changeOperand(rightEndJumpPos, endPos)
+	return nil
+}
+
+// 赋值表达式的值为赋值后的值，因此在写入变量后重新读取一次
+//
+//	[OpGet x] <value> [op] OpSet x OpGet x
+//
+// 索引赋值由OpSetIndex完成，复合索引赋值先将被索引对象与索引保存在匿名变量中
+//
+//	<left> <index> [OpGet left OpGet index OpIndex] <value> [op] OpSetIndex
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	op, compound := compoundOperators[node.Operator]
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("identifier not found: %s", target.Value)
+		}
+		switch symbol.Scope {
+		case BuiltinScope:
+			return fmt.Errorf("cannot assign to builtin %s", target.Value)
+		}
+		if symbol.Const {
+			return &Error{Position: target.Pos(), Message: "cannot assign to constant " + target.Value}
+		}
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		err := c.compileAssignValue(node.Value, op, compound)
+		if err != nil {
+			return err
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		if !compound {
+			err = c.Compile(target.Index)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.emitOp(code.OpSetIndex)
+			return nil
+		}
+		left := c.symbolTable.DefineTemp()
+		c.defineSymbol(left)
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		index := c.symbolTable.DefineTemp()
+		c.defineSymbol(index)
+		c.loadSymbol(left)
+		c.loadSymbol(index)
+		c.loadSymbol(left)
+		c.loadSymbol(index)
+		c.emitOp(code.OpIndex)
+		err = c.compileAssignValue(node.Value, op, compound)
+		if err != nil {
+			return err
+		}
+		c.emitOp(code.OpSetIndex)
+	default:
+		return fmt.Errorf("cannot assign to %s", node.Target)
+	}
+	return nil
+}
+
+// 在符号表中定义let语句声明的变量
+func (c *Compiler) defineLet(node *ast.LetStatement) Symbol {
+	if node.IsConst() {
+		return c.symbolTable.DefineConst(node.Name.Value)
+	}
+	return c.symbolTable.Define(node.Name.Value)
+}
+
+// 函数体对函数自身名称赋值时，修改的是let定义的变量，与求值器一致
+// 因此先以null定义变量再编译函数，函数体通过捕获该变量而非OpCurrentClosure引用自身
+//
+//	[OpNull OpDefineLocal f] <closure> OpSet f
+func (c *Compiler) compileSelfAssigningLet(node *ast.LetStatement) error {
+	symbol := c.defineLet(node)
+	if symbol.Scope == LocalScope {
+		c.emitOp(code.OpNull)
+		c.defineSymbol(symbol)
+	}
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// 函数体（包括其中嵌套的函数）中是否存在对name的赋值
+func assignsTo(body *ast.BlockStatement, name string) bool {
+	found := false
+	ast.Inspect(body, func(node ast.Node) bool {
+		if assign, ok := node.(*ast.AssignExpression); ok {
+			if ident, ok := assign.Target.(*ast.Identifier); ok && ident.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// 编译被赋的值，复合赋值时与栈中的原值进行运算
+func (c *Compiler) compileAssignValue(value ast.Expression, op code.Opcode, compound bool) error {
+	err := c.Compile(value)
+	if err != nil {
+		return err
+	}
+	if compound {
+		c.emitOp(op)
+	}
+	return nil
+}
+
+// while循环，continue跳转至条件判断处
+//
+//	start: <cond> OpJumpNotTruthy end
+//	<body> OpJump start
+//	end:
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	startPos := len(c.currentInstructions())
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	exitJumpPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+	loop := c.enterLoop()
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.emitOp(code.OpJump, startPos)
+	endPos := len(c.currentInstructions())
+	c.changeOperand(exitJumpPos, endPos)
+	c.leaveLoop(loop, startPos, endPos)
+	return nil
+}
+
+// for-in循环使用两个匿名变量保存被遍历的对象与下标，continue跳转至下标自增处
+// 只有数组可以被遍历，由OpIterable在循环开始前检查
+//
+//	<iterable> OpIterable OpSet iter
+//	OpConstant 0 OpSet idx
+//	start: OpGet idx OpGetBuiltin len OpGet iter OpCall 1 OpLess OpJumpNotTruthy end
+//	OpGet iter OpGet idx OpIndex OpSet x
+//	<body>
+//	next: OpGet idx OpConstant 1 OpAdd OpSet idx OpJump start
+//	end:
+func (c *Compiler) compileFor(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emitOp(code.OpIterable)
+	iter := c.symbolTable.DefineTemp()
+	c.defineSymbol(iter)
+	idx := c.symbolTable.DefineTemp()
+	c.emitOp(code.OpConstant, c.pushConstant(&object.Integer{Value: 0}))
+	c.defineSymbol(idx)
+
+	startPos := len(c.currentInstructions())
+	c.loadSymbol(idx)
+	c.emitOp(code.OpGetBuiltin, builtinIndex("len"))
+	c.loadSymbol(iter)
+	c.emitOp(code.OpCall, 1)
+	c.emitOp(code.OpLess)
+	exitJumpPos := c.emitOp(code.OpJumpNotTruthy, 9999)
+
+	c.loadSymbol(iter)
+	c.loadSymbol(idx)
+	c.emitOp(code.OpIndex)
+	// 循环变量只在循环体中可见
+	c.enterBlock()
+	c.defineSymbol(c.symbolTable.Define(node.Variable.Value))
+
+	loop := c.enterLoop()
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.leaveBlock()
+	nextPos := len(c.currentInstructions())
+	c.loadSymbol(idx)
+	c.emitOp(code.OpConstant, c.pushConstant(&object.Integer{Value: 1}))
+	c.emitOp(code.OpAdd)
+	c.storeSymbol(idx)
+	c.emitOp(code.OpJump, startPos)
+	endPos := len(c.currentInstructions())
+	c.changeOperand(exitJumpPos, endPos)
+	c.leaveLoop(loop, nextPos, endPos)
+	return nil
+}
+
+// try语句，try块与catch块中的错误由异常表中的项跳转至对应的处理代码，
+// 处理代码开始执行时栈中压入了被捕获的异常。正常执行完毕时内联执行finally块
+//
+//	try:     <block> [<finally>] OpJump end
+//	catch:   OpSet e <catch> [<finally>] OpJump end
+//	finally: OpSet tmp <finally> OpGet tmp OpThrow
+//	end:
+//
+// 没有finally时不生成finally的处理代码，catch块也不受保护
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	var endJumps []int
+	body := c.enterTry(node.Finally)
+	err := c.Compile(node.Block)
+	if err != nil {
+		return err
+	}
+	c.leaveTry(body)
+	// 仍需交给finally处理代码的区域
+	unhandled := body
+	err = c.compileFinally(node.Finally)
+	if err != nil {
+		return err
+	}
+	endJumps = append(endJumps, c.emitOp(code.OpJump, 9999))
+
+	if node.Catch != nil {
+		c.enterHandler(body)
+		c.enterBlock()
+		c.defineSymbol(c.symbolTable.Define(node.Param.Value))
+		unhandled = nil
+		if node.Finally != nil {
+			unhandled = c.enterTry(node.Finally)
+		}
+		err = c.Compile(node.Catch)
+		if err != nil {
+			return err
+		}
+		if unhandled != nil {
+			c.leaveTry(unhandled)
+		}
+		c.leaveBlock()
+		err = c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emitOp(code.OpJump, 9999))
+	}
+
+	if node.Finally != nil {
+		// 执行finally块后重新抛出被捕获的异常
+		c.enterHandler(unhandled)
+		exception := c.symbolTable.DefineTemp()
+		c.defineSymbol(exception)
+		err = c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.loadSymbol(exception)
+		c.emitOp(code.OpThrow)
+	}
+
+	endPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+// 进入受保护区域，finally为离开区域时需要执行的finally块
+func (c *Compiler) enterTry(finally *ast.BlockStatement) *tryContext {
+	scope := &c.scopes[c.scopeIndex]
+	try := &tryContext{finally: finally, start: len(scope.instructions), depth: scope.depth}
+	scope.tries = append(scope.tries, try)
+	return try
+}
+
+func (c *Compiler) leaveTry(try *tryContext) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	c.closeRange(try)
+}
+
+// 关闭受保护区域当前的区间，将其加入异常表
+// 异常表按区间关闭的先后排列，嵌套时内层的区间先关闭，因此排在外层之前
+func (c *Compiler) closeRange(try *tryContext) {
+	scope := &c.scopes[c.scopeIndex]
+	end := len(scope.instructions)
+	if try.start >= 0 && try.start < end {
+		try.handlers = append(try.handlers, len(scope.handlers))
+		scope.handlers = append(scope.handlers, code.Handler{Start: try.start, End: end, Depth: try.depth})
+	}
+	try.start = -1
+}
+
+// 在当前位置生成受保护区域的处理代码，回填异常表中的跳转目标
+// 处理代码开始执行时栈恢复到进入区域时的深度，并压入被捕获的异常
+func (c *Compiler) enterHandler(try *tryContext) {
+	scope := &c.scopes[c.scopeIndex]
+	for _, i := range try.handlers {
+		scope.handlers[i].Target = len(scope.instructions)
+	}
+	scope.depth = try.depth + 1
+}
+
+// 内联finally块，其中的语句不改变栈的深度
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	return c.Compile(finally)
+}
+
+// return、break与continue离开受保护区域前，由内向外依次执行各区域的finally块
+// outer为不需要离开的外层区域的个数。内联的finally块不受被离开的区域保护，
+// 其中的return等语句也只需离开更外层的区域
+func (c *Compiler) leaveTries(outer int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	// 最外层带有finally的区域，更外层的区域无需处理
+	first := len(tries)
+	for i := outer; i < len(tries); i++ {
+		if tries[i].finally != nil {
+			first = i
+			break
+		}
+	}
+	for i := len(tries) - 1; i >= first; i-- {
+		c.closeRange(tries[i])
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		if err := c.compileFinally(tries[i].finally); err != nil {
+			return err
+		}
+	}
+	c.scopes[c.scopeIndex].tries = tries
+	for _, try := range tries[first:] {
+		try.start = len(c.currentInstructions())
+	}
+	return nil
+}
+
+// 进入语句块，块中定义的变量在离开语句块后不可见
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) enterLoop() *loopContext {
+	scope := &c.scopes[c.scopeIndex]
+	loop := &loopContext{tries: len(scope.tries), depth: scope.depth}
+	scope.loops = append(scope.loops, loop)
+	return loop
+}
+
+// 离开循环，回填continue与break的跳转目标
+func (c *Compiler) leaveLoop(loop *loopContext, continuePos, breakPos int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	for _, pos := range loop.continueJumps {
+		c.changeOperand(pos, continuePos)
+	}
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, breakPos)
+	}
+}
+
+// 弹出循环中的表达式已压栈的值，生成break或continue的跳转指令，返回其地址以待回填
+// 跳转之后的代码不可达，编译时仍按跳转前的栈深度处理
+func (c *Compiler) emitLoopJump(loop *loopContext) int {
+	depth := c.scopes[c.scopeIndex].depth
+	for i := loop.depth; i < depth; i++ {
+		c.emitOp(code.OpPop)
+	}
+	pos := c.emitOp(code.OpJump, 9999)
+	c.scopes[c.scopeIndex].depth = depth
+	return pos
+}
+
+// 当前作用域中最内层的循环，不在循环中时返回nil
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// 内置函数在object.Builtins中的位置，不受同名变量的影响
+func builtinIndex(name string) int {
+	for i, def := range object.Builtins {
+		if def.Name == name {
+			return i
+		}
+	}
+	panic("unknown builtin " + name)
+}
+
+// 生成定义变量的指令，局部变量每次定义都是新的绑定，与此前被捕获的变量无关
+func (c *Compiler) defineSymbol(s Symbol) {
+	if s.Scope == LocalScope {
+		c.emitOp(code.OpDefineLocal, s.Index)
+		return
+	}
+	c.storeSymbol(s)
+}
+
+// 根据符号的作用域生成写入指令
+// 被捕获的变量存放在共享单元中，写入对定义变量的函数与所有捕获它的闭包可见
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emitOp(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emitOp(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emitOp(code.OpSetFree, s.Index)
+	}
+}
+
+// 根据符号的作用域生成读取指令
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emitOp(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emitOp(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emitOp(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emitOp(code.OpCurrentClosure)
+	case BuiltinScope:
+		c.emitOp(code.OpGetBuiltin, s.Index)
+	}
+}
+
+// 生成将被捕获变量压栈的指令，供OpClosure收集
+// 局部变量与自由变量以共享单元的形式被捕获，函数自身则直接捕获当前闭包
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emitOp(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emitOp(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 // 压入常量池，返回在池中的索引
 func (c *Compiler) pushConstant(obj object.Object) int {
 	c.constants = append(c.constants, obj)
@@ -209,45 +1126,118 @@ func (c *Compiler) emitOp(op code.Opcode, oprands ...int) int {
 	ins := code.Make(op, oprands...)
 	pos := c.addIns(ins)
 	c.setLastInstruction(op, pos)
+	c.scopes[c.scopeIndex].positions[pos] = c.position
+	c.scopes[c.scopeIndex].depth += stackEffect(op, oprands...)
 	return pos
 }
 
+// 指令执行前后操作数栈深度的变化
+func stackEffect(op code.Opcode, operands ...int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpGetBuiltin, code.OpCurrentClosure,
+		code.OpCaptureLocal, code.OpCaptureFree:
+		return 1
+	case code.OpArray, code.OpHash:
+		return 1 - operands[0]
+	case code.OpClosure:
+		return 1 - operands[1]
+	case code.OpCall:
+		// 弹出函数与参数，压入返回值
+		return -operands[0]
+	case code.OpSetIndex:
+		return -2
+	case code.OpMinus, code.OpBang, code.OpBitNot, code.OpJump, code.OpReturn, code.OpIterable:
+		return 0
+	default:
+		// 二元运算、OpIndex以及弹出栈顶元素的指令
+		return -1
+	}
+}
+
 // 修改某一操作的操作数
 func (c *Compiler) changeOperand(opPos, operand int) {
-	op := code.Opcode(c.instructions[opPos])
+	op := code.Opcode(c.currentInstructions()[opPos])
 	newIns := code.Make(op, operand)
 	c.replaceIns(opPos, newIns)
 }
 
 // 记录两条历史指令
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
-	c.previousInstruction = c.lastInstruction
-	c.lastInstruction = EmittedInstruction{op, pos}
+	scope := &c.scopes[c.scopeIndex]
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{op, pos}
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
 }
 
 // 将指令码置入指令流中，返回指令码的起始地址
 func (c *Compiler) addIns(ins code.Instructions) int {
-	pos := len(c.instructions)
-	c.instructions = append(c.instructions, ins...)
+	pos := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
 	return pos
 }
 
 func (c *Compiler) replaceIns(pos int, newIns code.Instructions) {
+	ins := c.currentInstructions()
 	for i := 0; i < len(newIns); i++ {
-		c.instructions[i+pos] = newIns[i]
+		ins[i+pos] = newIns[i]
 	}
 }
 
 // 移除字节码最后的OpPop指令
 func (c *Compiler) removeLastPop() {
-	c.instructions = c.instructions[:c.lastInstruction.Position]
-	c.lastInstruction = c.previousInstruction
+	scope := &c.scopes[c.scopeIndex]
+	delete(scope.positions, scope.lastInstruction.Position)
+	scope.instructions = scope.instructions[:scope.lastInstruction.Position]
+	scope.lastInstruction = scope.previousInstruction
+	scope.depth++
+}
+
+// 将函数体最后的OpPop替换为OpReturnValue，两者均无操作数
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceIns(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+// 进入新的编译作用域，同时进入嵌套的符号表
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+		positions:           map[int]token.Position{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// 离开当前编译作用域，返回该作用域中生成的指令流
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
-		Instructions: c.instructions,
+		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Handlers:     c.scopes[c.scopeIndex].handlers,
 	}
 }
 
@@ -256,4 +1246,8 @@ func (c *Compiler) Bytecode() *Bytecode {
 type Bytecode struct {
 	Instructions []byte
 	Constants    []object.Object
+	// Positions 主程序中指令的起始地址到源码位置的映射
+	Positions map[int]token.Position
+	// Handlers 主程序的异常表
+	Handlers []code.Handler
 }
@@ -7,6 +7,7 @@ import (
 	"monkey_cc/lexer"
 	"monkey_cc/object"
 	"monkey_cc/parser"
+	"strings"
 	"testing"
 )
 
@@ -42,6 +43,17 @@ func testIntegerObject(val object.Object, expected int64) error {
 	return nil
 }
 
+func testStringObject(val object.Object, expected string) error {
+	result, ok := val.(*object.String)
+	if !ok {
+		return fmt.Errorf(NOT_EXPECTED, "val.(type)", "*object.String", val.Type())
+	}
+	if result.Value != expected {
+		return fmt.Errorf(NOT_EXPECTED, "result.Value", expected, result.Value)
+	}
+	return nil
+}
+
 func testInstructions(val code.Instructions, expected []code.Instructions) error {
 	concatted := code.Instructions{}
 	for _, ins := range expected {
@@ -69,6 +81,28 @@ func testConstants(val []object.Object, expected []interface{}, t *testing.T) er
 			if err != nil {
 				return fmt.Errorf(CONSTANTS_ERROR, err)
 			}
+		case float64:
+			f, ok := val[i].(*object.Float)
+			if !ok {
+				return fmt.Errorf(NOT_EXPECTED, "val[i].(type)", "*object.Float", val[i].Type())
+			}
+			if f.Value != constant {
+				return fmt.Errorf(NOT_EXPECTED, "f.Value", constant, f.Value)
+			}
+		case string:
+			err := testStringObject(val[i], constant)
+			if err != nil {
+				return fmt.Errorf(CONSTANTS_ERROR, err)
+			}
+		case []code.Instructions:
+			fn, ok := val[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf(NOT_EXPECTED, "val[i].(type)", "*object.CompiledFunction", val[i].Type())
+			}
+			err := testInstructions(fn.Instructions, constant)
+			if err != nil {
+				return fmt.Errorf(INSTRUCTIONS_ERROR, err)
+			}
 		}
 	}
 	return nil
@@ -153,6 +187,67 @@ func TestIntegerArithmetic(t *testing.T) {
 	runTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-2.5e3",
+			expectedConstants: []interface{}{2500.0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestModAndComparison(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "7 % 3",
+			expectedConstants: []interface{}{7, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
 func TestBooleanExp(t *testing.T) {
 	tests := []compilerTest{
 		{
@@ -183,11 +278,11 @@ func TestBooleanExp(t *testing.T) {
 		},
 		{
 			input:             "1 > 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpLess),
+				code.Make(code.OpGreater),
 				code.Make(code.OpPop),
 			},
 		},
@@ -215,6 +310,117 @@ func TestBooleanExp(t *testing.T) {
 	runTests(t, tests)
 }
 
+func TestBitwiseExp(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "6 & 3",
+			expectedConstants: []interface{}{6, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitAnd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "6 | 3",
+			expectedConstants: []interface{}{6, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitOr),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "6 ^ 3",
+			expectedConstants: []interface{}{6, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitXor),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 << 2 >> 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShl),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpShr),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "~6",
+			expectedConstants: []interface{}{6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestLogicalExp(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
 func TestPrefixExp(t *testing.T) {
 	tests := []compilerTest{
 		{
@@ -289,65 +495,1292 @@ func TestIfExp(t *testing.T) {
 	runTests(t, tests)
 }
 
-func TestGlobalLetStmt(t *testing.T) {
+func TestLoops(t *testing.T) {
 	tests := []compilerTest{
 		{
-			input: `let one = 1;
-					let two = 2;`,
-			expectedConstants: []interface{}{1, 2},
+			input:             "while (true) { break; continue; } 1;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpJump, 13),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpJump, 0),
+				// 0013
+				code.Make(code.OpConstant, 0),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 被遍历的数组与下标保存在两个匿名的全局变量中
+			input:             "for (x in [1]) { x; continue; }",
+			expectedConstants: []interface{}{1, 0, 1},
 			expectedInstructions: []code.Instructions{
+				// 0000
 				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIterable),
+				// 0007
 				code.Make(code.OpSetGlobal, 0),
+				// 0010
 				code.Make(code.OpConstant, 1),
+				// 0013
 				code.Make(code.OpSetGlobal, 1),
+				// 0016
+				code.Make(code.OpGetGlobal, 1),
+				// 0019
+				code.Make(code.OpGetBuiltin, 0),
+				// 0021
+				code.Make(code.OpGetGlobal, 0),
+				// 0024
+				code.Make(code.OpCall, 1),
+				// 0026
+				code.Make(code.OpLess),
+				// 0027
+				code.Make(code.OpJumpNotTruthy, 60),
+				// 0030
+				code.Make(code.OpGetGlobal, 0),
+				// 0033
+				code.Make(code.OpGetGlobal, 1),
+				// 0036
+				code.Make(code.OpIndex),
+				// 0037
+				code.Make(code.OpSetGlobal, 2),
+				// 0040
+				code.Make(code.OpGetGlobal, 2),
+				// 0043
+				code.Make(code.OpPop),
+				// 0044
+				code.Make(code.OpJump, 47),
+				// 0047
+				code.Make(code.OpGetGlobal, 1),
+				// 0050
+				code.Make(code.OpConstant, 2),
+				// 0053
+				code.Make(code.OpAdd),
+				// 0054
+				code.Make(code.OpSetGlobal, 1),
+				// 0057
+				code.Make(code.OpJump, 16),
 			},
 		},
+	}
+	runTests(t, tests)
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []compilerTest{
 		{
-			input: `let one = 1;
-					one;`,
+			input:             "try { 1; } catch (e) { e; }",
 			expectedConstants: []interface{}{1},
 			expectedInstructions: []code.Instructions{
+				// 0000
 				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpPop),
+				// 0004
+				code.Make(code.OpJump, 17),
+				// 0007 catch
 				code.Make(code.OpSetGlobal, 0),
+				// 0010
 				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpJump, 17),
+			},
+		},
+		{
+			// finally块在正常执行的路径上内联，在处理代码中执行后重新抛出异常
+			input:             "try { 1; } finally { 2; }",
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpPop),
+				// 0004
+				code.Make(code.OpConstant, 1),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 22),
+				// 0011 finally
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
 				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpGetGlobal, 0),
+				// 0021
+				code.Make(code.OpThrow),
+			},
+		},
+		{
+			input:             `throw "a";`,
+			expectedConstants: []interface{}{"a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
 			},
 		},
 	}
 	runTests(t, tests)
 }
 
-func TestDefine(t *testing.T) {
-	expected := map[string]Symbol{
-		"a": {Name: "a", Scope: GlobalScope, Index: 0},
-		"b": {Name: "b", Scope: GlobalScope, Index: 1},
-	}
-	global := NewSymbolTable()
-	a := global.Define("a")
-	if a != expected["a"] {
-		t.Errorf(NOT_EXPECTED, "a", expected["a"], a)
+func TestExceptionTable(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []code.Handler
+	}{
+		{"try { 1; } catch (e) { e; }", []code.Handler{{Start: 0, End: 4, Target: 7, Depth: 0}}},
+		{"try { 1; } finally { 2; }", []code.Handler{{Start: 0, End: 4, Target: 11, Depth: 0}}},
+		// catch块受finally的处理代码保护
+		{
+			"try { 1; } catch (e) { 2; } finally { 3; }",
+			[]code.Handler{{Start: 0, End: 4, Target: 11, Depth: 0}, {Start: 14, End: 18, Target: 25, Depth: 0}},
+		},
+		// 内层的项在前，处理代码位于外层的区间中
+		{
+			"try { try { 1; } catch (e) { 2; } } catch (e) { 3; }",
+			[]code.Handler{{Start: 0, End: 4, Target: 7, Depth: 0}, {Start: 0, End: 17, Target: 20, Depth: 0}},
+		},
+		// 处理代码开始执行时，栈中保留try语句之前压入的1
+		{"1 + if (true) { try { 2; } catch (e) { 3; } 4 };", []code.Handler{{Start: 7, End: 11, Target: 14, Depth: 1}}},
 	}
-	b := global.Define("b")
-	if b != expected["b"] {
-		t.Errorf(NOT_EXPECTED, "b", expected["b"], b)
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf(COMPILER_ERROR, err)
+		}
+		handlers := compiler.Bytecode().Handlers
+		if len(handlers) != len(tt.expected) {
+			t.Fatalf("%q: expected handlers %v, found %v", tt.input, tt.expected, handlers)
+		}
+		for i, h := range tt.expected {
+			if handlers[i] != h {
+				t.Errorf("%q: expected handler %d to be %v, found %v", tt.input, i, h, handlers[i])
+			}
+		}
 	}
 }
 
-func TestResolveGlobal(t *testing.T) {
-	global := NewSymbolTable()
-	global.Define("a")
-	global.Define("b")
-	expect := []Symbol{
-		{Name: "a", Scope: GlobalScope, Index: 0},
-		{Name: "b", Scope: GlobalScope, Index: 1},
+func TestReturnThroughFinally(t *testing.T) {
+	// return之前内联finally块，内联的代码不受try语句保护，因此try块在异常表中分为两个区间
+	program := parse("fn() { try { return 1; } finally { 2; } }")
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf(COMPILER_ERROR, err)
 	}
-	for _, sym := range expect {
-		result, ok := global.Resolve(sym.Name)
-		if !ok {
-			t.Errorf("name %s not resolvable", sym.Name)
+	fn := compiler.Bytecode().Constants[4].(*object.CompiledFunction)
+	expectedInstructions := []code.Instructions{
+		// 0000
+		code.Make(code.OpConstant, 0),
+		// 0003
+		code.Make(code.OpConstant, 1),
+		// 0006
+		code.Make(code.OpPop),
+		// 0007
+		code.Make(code.OpReturnValue),
+		// 0008
+		code.Make(code.OpConstant, 2),
+		// 0011
+		code.Make(code.OpPop),
+		// 0012
+		code.Make(code.OpJump, 24),
+		// 0015 finally
+		code.Make(code.OpDefineLocal, 0),
+		// 0017
+		code.Make(code.OpConstant, 3),
+		// 0020
+		code.Make(code.OpPop),
+		// 0021
+		code.Make(code.OpGetLocal, 0),
+		// 0023
+		code.Make(code.OpThrow),
+		// 0024
+		code.Make(code.OpReturn),
+	}
+	if err := testInstructions(fn.Instructions, expectedInstructions); err != nil {
+		t.Fatalf(INSTRUCTIONS_ERROR, err)
+	}
+	expectedHandlers := []code.Handler{
+		{Start: 0, End: 3, Target: 15, Depth: 0},
+		{Start: 7, End: 8, Target: 15, Depth: 0},
+	}
+	if len(fn.Handlers) != len(expectedHandlers) {
+		t.Fatalf(WRONG_LENGTH, "fn.Handlers", len(expectedHandlers), len(fn.Handlers))
+	}
+	for i, h := range expectedHandlers {
+		if fn.Handlers[i] != h {
+			t.Errorf(NOT_EXPECTED, "handler", h, fn.Handlers[i])
 		}
-		if result != sym {
-			t.Errorf(NOT_EXPECTED, sym.Name, sym, result)
+	}
+}
+
+func TestAssignExp(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "let x = 1; x += 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] = 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 被索引对象与索引只求值一次
+			input:             "let a = [1]; a[0] -= 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 2),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetGlobal, 2),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetGlobal, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSub),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a = 1; } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 函数体对自身名称赋值时，引用的是let定义的变量而非当前闭包
+			input: "fn() { let f = fn() { f = 1; }; }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpDefineLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestAssignErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"x = 1;", "1:1: identifier not found: x"},
+		{"len = 1;", "1:1: cannot assign to builtin len"},
+	}
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("%q: expected compile error", tt.input)
+			continue
+		}
+		if err.Error() != tt.error {
+			t.Errorf("%q: expected error %q, found %q", tt.input, tt.error, err.Error())
+		}
+	}
+}
+
+// 解析出错的语法树中缺少节点时报告错误而不是崩溃
+func TestIncompleteSyntaxTree(t *testing.T) {
+	program := parse("let a = 1;")
+	program.Statements[0].(*ast.LetStatement).Value = nil
+	comp := New()
+	err := comp.Compile(program)
+	if err == nil {
+		t.Fatalf("expected compile error")
+	}
+	expect := "1:1: incomplete syntax tree, the program has parse errors"
+	if err.Error() != expect {
+		t.Errorf("expected error %q, found %q", expect, err.Error())
+	}
+}
+
+func TestConstErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"const x = 1; x = 2;", "1:14: cannot assign to constant x"},
+		{"const x = 1; x += 2;", "1:14: cannot assign to constant x"},
+		{"const x = 1;\nlet x = 2;", "2:5: cannot redefine constant x"},
+		{"const x = 1; const x = 2;", "1:20: cannot redefine constant x"},
+		// 闭包中捕获的常量同样不能赋值
+		{"fn() { const x = 1; fn() { x = 2; } }", "1:28: cannot assign to constant x"},
+	}
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("%q: expected compile error", tt.input)
+			continue
+		}
+		if err.Error() != tt.error {
+			t.Errorf("%q: expected error %q, found %q", tt.input, tt.error, err.Error())
+		}
+	}
+}
+
+// 局部变量的索引与调用的参数个数使用单字节操作数，超出范围时报告错误而不是截断
+func TestByteOperandLimits(t *testing.T) {
+	// n个局部变量的函数，被立即调用
+	locals := func(n int) string {
+		var out strings.Builder
+		out.WriteString("fn() {")
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&out, " let %s = %d;", ident(i), i)
+		}
+		out.WriteString(" }()")
+		return out.String()
+	}
+	// n个参数的函数，以n个实参调用
+	call := func(n int) string {
+		params := make([]string, n)
+		args := make([]string, n)
+		for i := range params {
+			params[i] = ident(i)
+			args[i] = fmt.Sprint(i)
+		}
+		return fmt.Sprintf("fn(%s) { 0 }(%s)", strings.Join(params, ", "), strings.Join(args, ", "))
+	}
+	// 外层函数定义n个局部变量，内层闭包捕获全部变量
+	captures := func(n int) string {
+		var out strings.Builder
+		out.WriteString("fn() {")
+		names := make([]string, n)
+		for i := range names {
+			names[i] = ident(i)
+			fmt.Fprintf(&out, " let %s = %d;", names[i], i)
+		}
+		fmt.Fprintf(&out, " fn() { [%s] } }", strings.Join(names, ", "))
+		return out.String()
+	}
+	tests := []struct {
+		input string
+		error string
+	}{
+		{locals(256), ""},
+		{locals(257), "too many local variables in function: 257, at most 256"},
+		{call(255), ""},
+		{call(256), "too many arguments in call: 256, at most 255"},
+		{"len(" + strings.Repeat("1, ", 255) + "1)", "too many arguments in call: 256, at most 255"},
+		{captures(255), ""},
+		{captures(256), "too many captured variables in function: 256, at most 255"},
+	}
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if tt.error == "" {
+			if err != nil {
+				t.Errorf("unexpected compile error: %s", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected compile error %q", tt.error)
+		} else if msg := err.(*Error).Message; msg != tt.error {
+			t.Errorf("expected error %q, found %q", tt.error, msg)
+		}
+	}
+}
+
+// 第i个不同的标识符，标识符中不能含有数字
+func ident(i int) string {
+	name := ""
+	for ; i >= 0; i = i/26 - 1 {
+		name = string(rune('a'+i%26)) + name
+	}
+	return "v" + name
+}
+
+func TestConstShadowing(t *testing.T) {
+	tests := []string{
+		// 内层作用域可以遮蔽外层的常量，常量的元素可以修改
+		"const x = 1; fn() { let x = 2; x = 3; }",
+		"const x = 1; fn(x) { x = 2; }",
+		"const a = [1]; a[0] = 2;",
+		"let x = 1; const x = 2;",
+		"const x = [1]; for (x in x) { x }",
+		"const x = 1; if (true) { const x = 2; }",
+	}
+	for _, input := range tests {
+		comp := New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Errorf("%q: unexpected compile error: %s", input, err)
+		}
+	}
+}
+
+func TestGlobalLetStmt(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input: `let one = 1;
+					let two = 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: `let one = 1;
+					one;`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestStringExp(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             `"monkey"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []interface{}{"mon", "key"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" == "key"`,
+			expectedConstants: []interface{}{"mon", "key"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestArrayLiteral(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "[]",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1, 2 + 3]",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestHashLiteral(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{2: 4, 1: 2 * 3}",
+			expectedConstants: []interface{}{1, 2, 3, 2, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestIndexExp(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             "[1, 2][1]",
+			expectedConstants: []interface{}{1, 2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{"a": 1}["a"]`,
+			expectedConstants: []interface{}{"a", 1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input: `fn() { return 5 + 10 }`,
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { 5 + 10 }`,
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestFunctionCalls(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input: `fn() { 24 }();`,
+			expectedConstants: []interface{}{
+				24,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let oneArg = fn(a) { a }; oneArg(24);`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let manyArg = fn(a, b, c) { a; b; c }; manyArg(24, 25, 26);`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+				25,
+				26,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpCall, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestLetStmtScopes(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input: `let num = 55; fn() { num }`,
+			expectedConstants: []interface{}{
+				55,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let a = 55; let b = 77; a + b }`,
+			expectedConstants: []interface{}{
+				55,
+				77,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpDefineLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDefineLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input: `fn(a) { fn(b) { a + b } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { fn(b) { fn(c) { a + b + c } } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input: `let countDown = fn(x) { countDown(x - 1); }; countDown(1);`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let wrapper = fn() { let countDown = fn(x) { countDown(x - 1); }; countDown(1); }; wrapper();`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpDefineLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTest{
+		{
+			input:             `len("monkey");`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `len([]);`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { len([]) }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestCompilerScopes(t *testing.T) {
+	compiler := New()
+	if compiler.scopeIndex != 0 {
+		t.Fatalf(NOT_EXPECTED, "scopeIndex", 0, compiler.scopeIndex)
+	}
+	globalSymbolTable := compiler.symbolTable
+
+	compiler.emitOp(code.OpMul)
+
+	compiler.enterScope()
+	if compiler.scopeIndex != 1 {
+		t.Fatalf(NOT_EXPECTED, "scopeIndex", 1, compiler.scopeIndex)
+	}
+	compiler.emitOp(code.OpSub)
+	if len(compiler.scopes[compiler.scopeIndex].instructions) != 1 {
+		t.Errorf(WRONG_LENGTH, "instructions", 1, len(compiler.scopes[compiler.scopeIndex].instructions))
+	}
+	if compiler.symbolTable.Outer != globalSymbolTable {
+		t.Errorf("compiler did not enclose symbolTable")
+	}
+
+	compiler.leaveScope()
+	if compiler.scopeIndex != 0 {
+		t.Fatalf(NOT_EXPECTED, "scopeIndex", 0, compiler.scopeIndex)
+	}
+	if compiler.symbolTable != globalSymbolTable {
+		t.Errorf("compiler did not restore global symbol table")
+	}
+
+	compiler.emitOp(code.OpAdd)
+	if len(compiler.scopes[compiler.scopeIndex].instructions) != 2 {
+		t.Errorf(WRONG_LENGTH, "instructions", 2, len(compiler.scopes[compiler.scopeIndex].instructions))
+	}
+	last := compiler.scopes[compiler.scopeIndex].lastInstruction
+	if last.Opcode != code.OpAdd {
+		t.Errorf(NOT_EXPECTED, "lastInstruction.Opcode", code.OpAdd, last.Opcode)
+	}
+	previous := compiler.scopes[compiler.scopeIndex].previousInstruction
+	if previous.Opcode != code.OpMul {
+		t.Errorf(NOT_EXPECTED, "previousInstruction.Opcode", code.OpMul, previous.Opcode)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	program := parse("1;\n  2 + 3;")
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf(COMPILER_ERROR, err)
+	}
+	positions := compiler.Bytecode().Positions
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "1:1"},  // OpConstant 0
+		{3, "1:1"},  // OpPop
+		{4, "2:3"},  // OpConstant 1
+		{7, "2:7"},  // OpConstant 2
+		{10, "2:5"}, // OpAdd
+		{11, "2:3"}, // OpPop
+	}
+	for _, tt := range tests {
+		if positions[tt.offset].String() != tt.expected {
+			t.Errorf(NOT_EXPECTED, fmt.Sprintf("position at %d", tt.offset), tt.expected, positions[tt.offset])
+		}
+	}
+}
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+	}
+	global := NewSymbolTable()
+	a := global.Define("a")
+	if a != expected["a"] {
+		t.Errorf(NOT_EXPECTED, "a", expected["a"], a)
+	}
+	b := global.Define("b")
+	if b != expected["b"] {
+		t.Errorf(NOT_EXPECTED, "b", expected["b"], b)
+	}
+}
+
+func TestResolveGlobal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+	expect := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+	}
+	for _, sym := range expect {
+		result, ok := global.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+		}
+		if result != sym {
+			t.Errorf(NOT_EXPECTED, sym.Name, sym, result)
+		}
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expect := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "d", Scope: LocalScope, Index: 1},
+	}
+	for _, sym := range expect {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+		}
+		if result != sym {
+			t.Errorf(NOT_EXPECTED, sym.Name, sym, result)
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+
+	expect := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 0},
+		{Name: "e", Scope: LocalScope, Index: 0},
+	}
+	for _, sym := range expect {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+		}
+		if result != sym {
+			t.Errorf(NOT_EXPECTED, sym.Name, sym, result)
+		}
+	}
+
+	expectFree := []Symbol{
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+	if len(secondLocal.FreeSymbols) != len(expectFree) {
+		t.Fatalf(WRONG_LENGTH, "FreeSymbols", len(expectFree), len(secondLocal.FreeSymbols))
+	}
+	for i, sym := range expectFree {
+		if secondLocal.FreeSymbols[i] != sym {
+			t.Errorf(NOT_EXPECTED, "FreeSymbols[i]", sym, secondLocal.FreeSymbols[i])
+		}
+	}
+
+	if _, ok := secondLocal.Resolve("z"); ok {
+		t.Errorf("name z resolved, but was expected not to")
+	}
+}
+
+func TestDefineConst(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	b := global.DefineConst("b")
+	expected := Symbol{Name: "b", Scope: GlobalScope, Index: 1, Const: true}
+	if b != expected {
+		t.Errorf(NOT_EXPECTED, "b", expected, b)
+	}
+	local := NewEnclosedSymbolTable(global)
+	resolved, ok := local.Resolve("b")
+	if !ok || resolved != expected {
+		t.Errorf(NOT_EXPECTED, "b", expected, resolved)
+	}
+}
+
+func TestBlockScope(t *testing.T) {
+	tests := []compilerTest{
+		{
+			// 块中的变量使用新的存储位置，离开语句块后外层的x重新可见
+			input:             "let x = 1; if (true) { let x = 2; x }; x;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 22),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpJump, 23),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { if (true) { let a = 1; a } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 14),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpDefineLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJump, 15),
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+
+	err := New().Compile(parse("if (true) { let x = 1; } x;"))
+	if err == nil || err.Error() != "1:26: identifier not found: x" {
+		t.Errorf("expected block binding to be invisible outside, found %v", err)
+	}
+}
+
+func TestResolveBlock(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+	block := NewBlockSymbolTable(local)
+	c := block.Define("c")
+	inner := NewBlockSymbolTable(block)
+	b2 := inner.Define("b")
+
+	// 语句块中的变量由所在函数分配存储位置
+	if expected := (Symbol{Name: "c", Scope: LocalScope, Index: 1}); c != expected {
+		t.Errorf(NOT_EXPECTED, "c", expected, c)
+	}
+	if expected := (Symbol{Name: "b", Scope: LocalScope, Index: 2}); b2 != expected {
+		t.Errorf(NOT_EXPECTED, "b", expected, b2)
+	}
+	if local.NumDefinitions() != 3 || inner.NumDefinitions() != 3 {
+		t.Errorf("expected 3 definitions, found %d", local.NumDefinitions())
+	}
+	// 外层语句块中的局部变量不会被记录为自由变量
+	if sym, ok := inner.Resolve("c"); !ok || sym != c {
+		t.Errorf(NOT_EXPECTED, "c", c, sym)
+	}
+	if len(local.FreeSymbols) != 0 {
+		t.Errorf("block lookups should not capture free variables")
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("c should be invisible outside its block")
+	}
+}
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expect := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+	result, ok := global.Resolve(expect.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expect.Name)
+	}
+	if result != expect {
+		t.Errorf(NOT_EXPECTED, expect.Name, expect, result)
+	}
+}
+
+func TestCloneAndRollback(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	clone := global.Clone()
+	clone.Define("a")
+	clone.Define("c")
+	clone.Define("d")
+	if _, ok := global.Resolve("c"); ok {
+		t.Fatalf("definition in clone leaked into the original table")
+	}
+	// 只有c被写入，a与d的新定义撤销
+	clone.Rollback(global, func(s Symbol) bool { return s.Name == "b" || s.Name == "c" })
+	expect := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: GlobalScope, Index: 3},
+	}
+	for _, sym := range expect {
+		result, ok := clone.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+		}
+		if result != sym {
+			t.Errorf(NOT_EXPECTED, sym.Name, sym, result)
+		}
+	}
+	if _, ok := clone.Resolve("d"); ok {
+		t.Errorf("name d should be rolled back")
+	}
+	if clone.NumDefinitions() != 5 {
+		t.Errorf(NOT_EXPECTED, "NumDefinitions()", 5, clone.NumDefinitions())
+	}
+}
+
+func TestDefineResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+
+	expect := []Symbol{
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+	}
+	for i, sym := range expect {
+		global.DefineBuiltin(i, sym.Name)
+	}
+
+	for _, table := range []*SymbolTable{global, firstLocal, secondLocal} {
+		for _, sym := range expect {
+			result, ok := table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf(NOT_EXPECTED, sym.Name, sym, result)
+			}
 		}
 	}
 }
@@ -0,0 +1,129 @@
+// Package diagnostic 定义结构化的诊断信息，并将其渲染为带有源码与下划线的文本
+package diagnostic
+
+import (
+	"fmt"
+	"io"
+	"monkey_cc/token"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Code 诊断的错误码，便于工具按种类过滤
+type Code string
+
+const (
+	UnexpectedToken     Code = "E0001" // 出现了与期望不符的词法单元
+	InvalidInteger      Code = "E0002" // 整数字面量无法解析
+	MissingExpression   Code = "E0003" // 该位置需要一个表达式
+	IllegalCharacter    Code = "E0004" // 词法分析遇到了非法字符
+	InvalidEscape       Code = "E0005" // 字符串中的转义序列不合法
+	UnterminatedString  Code = "E0006" // 字符串缺少结尾的引号
+	UnterminatedComment Code = "E0007" // 块注释缺少结尾的"*/"
+	InvalidNumber       Code = "E0008" // 数字字面量的格式不合法
+	OutsideLoop         Code = "E0009" // break或continue不在循环中
+	InvalidAssignment   Code = "E0010" // 赋值的目标不是变量或索引表达式
+	MissingHandler      Code = "E0011" // try语句既没有catch也没有finally
+)
+
+// Span 源码中的一段区间，End指向区间之后的第一个字符
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+// TokenSpan 返回词法单元在源码中覆盖的区间
+func TokenSpan(tok *token.Token) Span {
+	return Span{Start: tok.Pos, End: tok.End}
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Span     Span
+	Message  string
+	// Hint 可选的修改建议，为空时不输出
+	Hint string
+}
+
+// String 返回形如"3:14: message"的单行文本
+func (d Diagnostic) String() string {
+	return d.Span.Start.String() + ": " + d.Message
+}
+
+// Render 输出诊断信息与出错的源码行，并在出错位置下方标出下划线
+//
+//	3:14: error[E0001]: expected next token to be ), found ;
+//	  |
+//	3 | let c = add(a, b;
+//	  |                 ^
+//	  = hint: did you forget a closing `)`?
+func Render(w io.Writer, source string, d Diagnostic) {
+	start := d.Span.Start
+	fmt.Fprintf(w, "%s: %s[%s]: %s\n", start, d.Severity, d.Code, d.Message)
+
+	line, ok := sourceLine(source, start.Line)
+	if ok {
+		lineNo := fmt.Sprintf("%d", start.Line)
+		gutter := strings.Repeat(" ", len(lineNo))
+		fmt.Fprintf(w, "%s |\n", gutter)
+		fmt.Fprintf(w, "%s | %s\n", lineNo, line)
+		fmt.Fprintf(w, "%s | %s\n", gutter, underline(line, d.Span))
+	}
+	if d.Hint != "" {
+		fmt.Fprintf(w, "  = hint: %s\n", d.Hint)
+	}
+}
+
+// 返回第n行源码（从1开始计数）
+func sourceLine(source string, n int) (string, bool) {
+	lines := strings.Split(source, "\n")
+	if n < 1 || n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// 生成下划线，列号按Unicode字符计数，制表符原样保留以便与源码对齐
+// 区间跨越多行时，只标记到行尾
+func underline(line string, span Span) string {
+	var out strings.Builder
+	chars := []rune(line)
+	begin := span.Start.Column - 1
+	if begin > len(chars) {
+		begin = len(chars)
+	}
+	for _, ch := range chars[:begin] {
+		if ch == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	width := span.End.Column - span.Start.Column
+	if span.End.Line != span.Start.Line {
+		width = len(chars) - begin
+	}
+	if width < 1 {
+		width = 1
+	}
+	out.WriteString(strings.Repeat("^", width))
+	return out.String()
+}
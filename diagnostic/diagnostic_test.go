@@ -0,0 +1,69 @@
+package diagnostic
+
+import (
+	"bytes"
+	"monkey_cc/token"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	source := "let a = 1;\n\tlet b = \"two\";\n"
+	tok := token.New(token.STRING, "two")
+	tok.Pos = token.Position{Filename: "test.mk", Offset: 20, Line: 2, Column: 10}
+	tok.End = token.Position{Filename: "test.mk", Offset: 25, Line: 2, Column: 15}
+	d := Diagnostic{
+		Severity: Error,
+		Code:     UnexpectedToken,
+		Span:     TokenSpan(tok),
+		Message:  "unexpected string",
+		Hint:     "remove the string",
+	}
+	expect := "test.mk:2:10: error[E0001]: unexpected string\n" +
+		"  |\n" +
+		"2 | \tlet b = \"two\";\n" +
+		"  | \t        ^^^^^\n" +
+		"  = hint: remove the string\n"
+
+	var out bytes.Buffer
+	Render(&out, source, d)
+	if out.String() != expect {
+		t.Errorf("expected:\n%s\nfound:\n%s", expect, out.String())
+	}
+	if d.String() != "test.mk:2:10: unexpected string" {
+		t.Errorf("wrong String(): %q", d.String())
+	}
+}
+
+func TestRenderWithoutSource(t *testing.T) {
+	d := Diagnostic{
+		Severity: Warning,
+		Code:     InvalidInteger,
+		Span:     Span{Start: token.Position{Line: 3, Column: 1}},
+		Message:  "bad",
+	}
+	expect := "3:1: warning[E0002]: bad\n"
+
+	var out bytes.Buffer
+	Render(&out, "", d)
+	if out.String() != expect {
+		t.Errorf("expected:\n%s\nfound:\n%s", expect, out.String())
+	}
+}
+
+func TestRenderUnicode(t *testing.T) {
+	source := `let 名字 = "é" @;`
+	tok := token.New(token.ILLEGAL, "@")
+	tok.Pos = token.Position{Offset: 18, Line: 1, Column: 14}
+	tok.End = token.Position{Offset: 19, Line: 1, Column: 15}
+	d := Diagnostic{Code: IllegalCharacter, Span: TokenSpan(tok), Message: `illegal character "@"`}
+	expect := "1:14: error[E0004]: illegal character \"@\"\n" +
+		"  |\n" +
+		"1 | let 名字 = \"é\" @;\n" +
+		"  |              ^\n"
+
+	var out bytes.Buffer
+	Render(&out, source, d)
+	if out.String() != expect {
+		t.Errorf("expected:\n%s\nfound:\n%s", expect, out.String())
+	}
+}
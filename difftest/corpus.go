@@ -0,0 +1,274 @@
+package difftest
+
+// Corpus 两个引擎共用的测试语料
+// 新增语言特性时应在此补充对应的程序，使两个引擎的行为保持一致
+var Corpus = []Case{
+	// 整数运算
+	{"5", "5"},
+	{"-10", "-10"},
+	{"5 + 5 + 5 - 10", "5"},
+	{"2 * (5 + 10)", "30"},
+	{"5 + 10 / 2", "10"},
+	{"(1 + 3 * 5) * (-1)", "-16"},
+	{"6 & 3", "2"},
+	{"6 | 3", "7"},
+	{"1 | 2 & 3", "3"},
+	{"6 ^ 3", "5"},
+	{"~0", "-1"},
+	{"1 << 10 >> 3", "128"},
+	{"-7 % 3", "-1"},
+	{"10 % 4 * 2", "4"},
+	{"5.5 % 2", "1.5"},
+	{"1 % 0", "error: modulo by zero"},
+	{"1 >> -2", "error: negative shift count: -2"},
+	{"~1.5", "error: unknown operator: ~ FLOAT"},
+	{"1 / 0", "error: division by zero"},
+	{"0xFF + 0o17 + 0b1010", "280"},
+	{"1_000_000 / 1_000", "1000"},
+	{"0x7fff_ffff_ffff_ffff", "9223372036854775807"},
+	{"0x", "parse error: 1:1: hexadecimal literal has no digits"},
+	{"1__0", "parse error: 1:3: `_` must separate successive digits"},
+	{"0x8000000000000000", "parse error: 1:1: could not parse 0x8000000000000000 as integer"},
+
+	// 浮点数
+	{"3.14", "3.14"},
+	{"1.5 + 1.5", "3.0"},
+	{"1 + 0.5", "1.5"},
+	{"10 / 4.0", "2.5"},
+	{"-1e-3", "-0.001"},
+	{"1 == 1.0", "true"},
+	{"2.5 > 2", "true"},
+	{"int(2.9) + int(\"3\")", "5"},
+	{"float(1) / 4", "0.25"},
+	{"1.0 / 0", "error: division by zero"},
+	{"1.5 & 1", "error: type mismatch: FLOAT & INTEGER"},
+	{"{1.5: 1}", "error: unusable as hash key: FLOAT"},
+	{"1e", "parse error: 1:2: exponent has no digits"},
+
+	// 布尔运算与比较
+	{"true", "true"},
+	{"1 < 2", "true"},
+	{"1 > 2", "false"},
+	{"1 == 1", "true"},
+	{"1 != 1", "false"},
+	{"1 <= 1", "true"},
+	{"2 >= 3", "false"},
+	{"2.5 >= 2", "true"},
+	{"!(1 <= 2) == (1 > 2)", "true"},
+	{"1 > true", "error: type mismatch: INTEGER > BOOLEAN"},
+	{"true >= false", "error: unknown operator: BOOLEAN >= BOOLEAN"},
+	{`"b" > "a"`, "error: unknown operator: STRING > STRING"},
+	// 比较运算先求左值再求右值
+	{`let s = ""; let a = fn() { s += "a"; 1 }; let b = fn() { s += "b"; 2 }; [a() >= b(), a() > b(), s]`, `[false, false, "abab"]`},
+	{`let r = ""; try { fn() { throw "left" }() > fn() { throw "right" }() } catch (e) { r = e["message"] } r`, `"left"`},
+	{"true == false", "false"},
+	{"(1 < 2) == true", "true"},
+	{"!true", "false"},
+	{"!5", "false"},
+	{"!!true", "true"},
+	{"!(if (false) { 1 })", "true"},
+	{"true && false", "false"},
+	{"false || true", "true"},
+	{"false && len(1)", "false"},
+	{"true || len(1)", "true"},
+	{"1 && 0", "true"},
+	{"if (false) { 1 } || 0", "true"},
+
+	// 字符串
+	{`"monkey"`, `"monkey"`},
+	{`"mon" + "key"`, `"monkey"`},
+	{`"a" == "a"`, "true"},
+	{`"a" != "b"`, "true"},
+	{`len("hello world")`, "11"},
+	{`"a\tb\n"`, `"a\tb\n"`},
+	{`"say \"hi\""`, `"say \"hi\""`},
+	{`len("\u{1F600}")`, "4"},
+	{"`raw\\n` + \"\\\\\"", `"raw\\n\\"`},
+	{"`two\nlines`", `"two\nlines"`},
+	{`"\q"`, `parse error: 1:2: unknown escape sequence \q`},
+	{`let 名字 = "猴子"; 名字 + "!"`, `"猴子!"`},
+	{`let größe = 3; größe * 2`, "6"},
+	{`1 @ 2`, `parse error: 1:3: illegal character "@"`},
+
+	// 注释
+	{"// only a comment\n1 + 1", "2"},
+	{"let a = 4; /* a /* nested */ comment */ a / 2 // trailing", "2"},
+	{"1 /* unterminated", "parse error: 1:3: unterminated block comment"},
+
+	// 条件表达式
+	{"if (true) { 10 }", "10"},
+	{"if (false) { 10 }", "null"},
+	{"if (1) { 10 }", "10"},
+	{"if (1 > 2) { 10 } else { 20 }", "20"},
+	{"if (true) { }", "null"},
+	{"if (true) { let a = 1; }", "null"},
+
+	// 变量绑定
+	{"let a = 5; a;", "5"},
+	{"let a = 5; let b = a; let c = a + b + 5; c;", "15"},
+	{"let a = 5;", "nil"},
+	{"let a = 5 + true; 1", "error: type mismatch: INTEGER + BOOLEAN"},
+	{"b", "error: identifier not found: b"},
+	{"let len = fn(x) { 42 }; len(1)", "42"},
+
+	// 数组与哈希表
+	{"[]", "[]"},
+	{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+	{"[1, 2, 3][1]", "2"},
+	{"[1, 2, 3][3]", "null"},
+	{"[1, 2, 3][-1]", "null"},
+	{"len([1, 2, 3])", "3"},
+	{"{}", "{}"},
+	{`{"one": 1 + 9, 4: 4}`, `{"one": 10, 4: 4}`},
+	{`{"a": 1}["a"]`, "1"},
+	{`{"a": 1}["b"]`, "null"},
+	{`{true: 1}[true]`, "1"},
+	{"{[1]: 1}", "error: unusable as hash key: ARRAY"},
+	{"{1: 1}[fn(x) { x }]", "error: unusable as hash key: FUNCTION"},
+	{"1[0]", "error: index operator not supported: INTEGER[INTEGER]"},
+	{`[1]["a"]`, "error: index operator not supported: ARRAY[STRING]"},
+
+	// 函数、闭包与递归
+	{"fn(x) { x; }", "fn"},
+	{"fn(x) { x; }(5)", "5"},
+	{"let add = fn(a, b) { return a + b; }; add(5, add(5, 5));", "15"},
+	{"let f = fn() { return 99; 100; }; f();", "99"},
+	{"let f = fn() { }; f();", "null"},
+	{"let f = fn() { let a = 1; }; f();", "null"},
+	{"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(1)(2)", "3"},
+	{"let a = 1; let f = fn(b) { fn(c) { a + b + c } }; f(2)(3)", "6"},
+	{"let fib = fn(x) { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) }; fib(15)", "610"},
+	{"let wrapper = fn() { let count = fn(x) { if (x == 0) { 0 } else { count(x - 1) } }; count(3) }; wrapper()", "0"},
+	// 深度递归，虚拟机最多支持MaxFrames层调用
+	{"let f = fn(n) { if (n == 0) { return 0; } n + f(n - 1) }; f(2000)", "2001000"},
+	{"let f = fn(n) { let a = [n, n]; if (n == 0) { return 0; } a[0] + f(n - 1) }; f(4000)", "8002000"},
+	{"return 10; 9;", "10"},
+	{"if (10 > 1) { if (10 > 1) { return 10; } } return 1;", "10"},
+	{"fn(a, b) { a + b; }(1);", "error: wrong number of arguments: want=2, got=1"},
+	{"1();", "error: not a function: INTEGER"},
+	{"len", "builtin"},
+	{"len(1)", "error: argument type INTEGER to `len` is not supported"},
+	{`len("one", "two")`, "error: wrong number of arguments, expect: 1, found: 2."},
+
+	// 循环
+	{"while (false) { 1 }", "nil"},
+	{"while (true) { break; } 5", "5"},
+	{"for (x in [1, 2, 3]) { x }", "nil"},
+	{"let last = 0; for (x in [1, 2, 3]) { last = x; if (x == 2) { break; } } last", "2"},
+	{"let f = fn(a) { for (x in a) { if (x % 2 == 0) { continue; } if (x > 3) { return x; } } }; f([1, 2, 4, 5, 6])", "5"},
+	{"let f = fn() { for (x in [1, 2]) { let last = 0; for (y in [3, 4]) { last = y; if (y == 4) { break; } } if (x == 2) { return [x, last]; } } }; f()", "[2, 4]"},
+	{"let f = fn() { while (true) { if (true) { return 7; } } }; f()", "7"},
+	{"let f = fn() { for (x in []) { return 1; } }; f()", "null"},
+	{"let f = fn(a) { for (x in a) { if (x > 1) { return fn() { x }; } } }; f([1, 2, 3])()", "2"},
+	{"for (x in 1) { x }", "error: cannot iterate over INTEGER"},
+	{`for (c in "ab") { c }`, "error: cannot iterate over STRING"},
+	{`for (k in {"a": 1}) { k }`, "error: cannot iterate over HASH"},
+	{"let s = 0; for (x in [1, 2]) { s += x; }; while (s < 10) { s *= 2; }; s", "12"},
+
+	// 赋值
+	{"let x = 1; x = 5; x", "5"},
+	{"let x = 10; x += 2; x -= 3; x *= 4; x /= 6; x", "6"},
+	{"let x = 1.5; x *= 2", "3.0"},
+	{`let s = "a"; s += "b"`, `"ab"`},
+	{"let a = 1; let b = 2; a = b = 3; [a, b]", "[3, 3]"},
+	{"let i = 0; let s = 0; while (i < 5) { i += 1; if (i == 3) { continue; } s += i; } s", "12"},
+	{"let i = 0; while (i < 5000) { i = i + 1; let y = [1, if (true) { continue; }]; } i", "5000"},
+	// 表达式中的break、continue与return中止整个表达式
+	{"let n = 0; let s = []; while (n < 3) { n += 1; let y = if (true) { break; }; s = [s, n]; } [n, s]", "[1, []]"},
+	{`let s = ""; for (c in [true, false]) { s += puts(if (c) { continue; }) + ""; } s`, "error: type mismatch: NULL + STRING"},
+	{"let r = 0; while (true) { r = 10 + if (true) { break; } else { 1 }; } r", "0"},
+	{"let s = 0; for (c in [1, 2, 3]) { s += -if (c == 2) { continue; } else { c }; } s", "-4"},
+	{"let f = fn() { let x = 1 + if (true) { return 5; }; x }; f()", "5"},
+	{"let f = fn() { [1, { 2: if (true) { return 3; } }] }; f()", "3"},
+	{"let x = 1; let f = fn() { x = 2; }; f(); x", "2"},
+	{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next()", "2"},
+	{"let mk = fn() { let c = 0; let inc = fn() { c += 1; c }; inc(); inc(); c }; mk()", "2"},
+	{"let mk = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = mk(); p[0](); p[0](); p[1]()", "2"},
+	{"let f = fn() { let a = 1; let g = fn() { fn() { a = a * 10 } }; g()(); a }; f()", "10"},
+	{"let f = fn() { let s = [0, 0]; for (x in [1, 2]) { s[x - 1] = fn() { x }; } [s[0](), s[1]()] }; f()", "[1, 2]"},
+	{"let f = fn() { f = 1 }; f(); f", "1"},
+	{"let g = fn() { let f = fn() { f = 2; 3 }; [f(), f] }; g()", "[3, 2]"},
+	{"let a = [1, 2, 3]; a[0] = a[2] = 7; a", "[7, 2, 7]"},
+	{"let a = [1, 2]; let b = a; b[1] *= 10; a", "[1, 20]"},
+	{`let h = {"a": 1}; h["a"] += 1; h["b"] = 5; h`, `{"a": 2, "b": 5}`},
+	{"y = 1", "error: identifier not found: y"},
+	{"len = 1", "error: cannot assign to builtin len"},
+	{"let a = [1]; a[1] = 2", "error: index out of range: 1"},
+	{`let s = "ab"; s[0] = "c"`, "error: index assignment not supported: STRING[INTEGER]"},
+	{"let x = 1; x += true", "error: type mismatch: INTEGER + BOOLEAN"},
+
+	// 常量
+	{"const x = 5; x * 2", "10"},
+	{"const x = 1; let f = fn() { let x = 2; x += 1; x }; f() + x", "4"},
+	{"const a = [1]; a[0] = 2; a", "[2]"},
+	{"const x = 1; x = 2", "error: cannot assign to constant x"},
+	{"const x = 1; let f = fn() { x += 1 }; f()", "error: cannot assign to constant x"},
+	{"const x = 1; let x = 2;", "error: cannot redefine constant x"},
+	{"const x = [1, 2]; let s = 0; for (x in x) { s += x; } s", "3"},
+
+	// 块作用域
+	{"if (true) { let x = 1; } x", "error: identifier not found: x"},
+	{"let x = 1; if (true) { let x = 2; x }", "2"},
+	{"let x = 1; if (true) { let x = 2; } x", "1"},
+	{"let x = 1; if (true) { x = 2; } x", "2"},
+	{"let x = 1; if (false) { 0 } else { let x = 3; x += 1; } x", "1"},
+	{"for (x in [1]) { x } x", "error: identifier not found: x"},
+	{"let i = 0; while (i < 3) { let j = i * 2; i += 1; } j", "error: identifier not found: j"},
+	{"let s = 0; for (x in [1, 2, 3]) { let d = x * 2; s += d; } s", "12"},
+	{"let f = fn(x) { if (x > 0) { let x = x * 10; return x; } x }; [f(1), f(-1)]", "[10, -1]"},
+	{"let f = fn() { let a = 1; if (true) { let b = 2; fn() { a + b } } }; f()()", "3"},
+	{"let f = fn() { for (x in [1, 2]) { if (x == 2) { return fn() { x * 10 }; } } }; f()()", "20"},
+	{"const c = 1; if (true) { const c = 2; c }", "2"},
+
+	// 异常处理
+	{`let r = 0; try { throw "boom"; r = 1; } catch (e) { r = e["message"]; } r`, `"boom"`},
+	{`let r = 0; try { 1 + true } catch (e) { r = [e["type"], e["message"], e["value"]]; } r`, `["RuntimeError", "type mismatch: INTEGER + BOOLEAN", null]`},
+	{`let r = 0; try { throw 42 } catch (e) { r = [e["type"], e["message"], e["value"], e["position"]]; } r`, `["Error", "42", 42, "1:18"]`},
+	{`let r = 0; try { throw {"code": 7} } catch (e) { r = e["value"]["code"]; } r`, "7"},
+	{`let r = 0; try { len(1) } catch (e) { r = e; } r`, "error: argument type INTEGER to `len` is not supported"},
+	{`let r = 0; try { fn(a) { a }() } catch (e) { r = e["message"]; } r`, `"wrong number of arguments: want=1, got=0"`},
+	{`let r = ""; try { r += "t"; } finally { r += "f"; } r`, `"tf"`},
+	{`let r = ""; try { try { throw "a" } finally { r += "f" } } catch (e) { r += e["message"] } r`, `"fa"`},
+	{`let n = 0; try { try { throw "a" } catch (e) { throw "b" } finally { n += 1 } } catch (e) { n = [n, e["message"]] } n`, `[1, "b"]`},
+	{`let n = 0; try { try { throw "a" } catch (e) { throw e } } catch (e) { n = e["position"] } n`, `"1:24"`},
+	{`try { throw "a" } catch (e) { 1 } e`, "error: identifier not found: e"},
+	{`let f = 0; try { throw "a" } catch (e) { f = fn() { e["message"] } } f()`, `"a"`},
+	{`throw "uncaught"`, "error: uncaught"},
+	{`try { throw "a" } finally { 1 }`, "error: a"},
+	{`try { 1 } catch (e) { 2 }`, "nil"},
+	{`if (true) { try { 1 } catch (e) { 2 } }`, "null"},
+	// 处理代码将栈恢复到try语句开始时的深度
+	{`[1, if (true) { try { throw "x" } catch (e) { 0 } 5 }, 3]`, "[1, 5, 3]"},
+	{`1 + if (true) { try { [2, 3, len(4)] } catch (e) { } 10 }`, "11"},
+	{`true && if (true) { try { throw 1 } catch (e) { } true }`, "true"},
+	// 跨越函数调用的异常
+	{`let f = fn(x) { if (x > 2) { throw "too big" } x }; let g = fn(a) { let b = a * 2; f(a) + b }; let r = 0; try { r = g(1); r = g(3); } catch (e) { r = [r, e["trace"]] } r`, `[3, ["f (1:30)", "g (1:85)"]]`},
+	{`let f = fn() { let x = 1; try { fn() { throw "in" }(); x = 2 } catch (e) { x = x + 10 } x }; f()`, "11"},
+	{`let f = fn() { try { throw "a" } catch (e) { return e["message"] } }; f()`, `"a"`},
+	{`let f = fn() { try { throw "a" } catch (e) { throw e["message"] + "!" } }; let r = 0; try { f() } catch (e) { r = [e["message"], e["trace"]] } r`, `["a!", ["f (1:46)"]]`},
+	// return、break与continue离开try语句时执行finally块
+	{`let n = 0; let f = fn() { try { return 1 } finally { n += 1 } }; [f(), n]`, "[1, 1]"},
+	{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+	{`let f = fn() { try { throw "a" } finally { return 2 } }; f()`, "2"},
+	{`let f = fn() { try { return 1 } finally { throw "b" } }; let r = 0; try { f() } catch (e) { r = e["message"] } r`, `"b"`},
+	{`let n = 0; let f = fn() { try { try { return 1 } finally { n += 1 } } finally { n += 10 } }; [f(), n]`, "[1, 11]"},
+	{`let n = 0; let f = fn() { try { throw "a" } catch (e) { return 1 } finally { n += 1 } }; [f(), n]`, "[1, 1]"},
+	{`let s = 0; let n = 0; for (i in [1, 2, 3]) { try { if (i == 2) { continue } s += i } finally { n += 1 } } [s, n]`, "[4, 3]"},
+	{`let s = 0; let n = 0; for (i in [1, 2, 3]) { try { if (i == 2) { break } s += i } finally { n += 1 } } [s, n]`, "[1, 2]"},
+	{`let n = 0; while (true) { try { try { break } finally { n += 1 } } finally { n += 10 } } n`, "11"},
+	{`let n = 0; while (n < 5) { try { n += 1; throw "x" } catch (e) { continue } finally { n += 10 } } n`, "11"},
+	{`let n = 0; while (true) { try { n += 1 } finally { if (n < 3) { continue } break } } n`, "3"},
+	{`let f = fn() { while (true) { try { return 1 } finally { break } } 2 }; f()`, "2"},
+	{`let r = 0; let f = fn() { try { try { return 1 } finally { throw "f" } } catch (e) { r = e["message"] } 2 }; [f(), r]`, `[2, "f"]`},
+
+	// 运算错误
+	{"5 + true;", "error: type mismatch: INTEGER + BOOLEAN"},
+	{"5 + true; 5;", "error: type mismatch: INTEGER + BOOLEAN"},
+	{"true + false", "error: unknown operator: BOOLEAN + BOOLEAN"},
+	{"if (10 > 1) { true + false; }", "error: unknown operator: BOOLEAN + BOOLEAN"},
+	{`"a" - "b"`, "error: unknown operator: STRING - STRING"},
+	{"true < false", "error: unknown operator: BOOLEAN < BOOLEAN"},
+	{"1 == true", "error: type mismatch: INTEGER == BOOLEAN"},
+	{"-true", "error: unknown operator: - BOOLEAN"},
+	{"[1] + [2]", "error: unknown operator: ARRAY + ARRAY"},
+}
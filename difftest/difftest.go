@@ -0,0 +1,156 @@
+// Package difftest 对比求值器与虚拟机的执行结果
+//
+// 同一段程序分别交给evaluator.Eval与vm.VM.Run执行，结果转化为规范的字符串形式后比较，
+// 两者的值或错误信息不一致时即为其中一个引擎的缺陷。
+package difftest
+
+import (
+	"errors"
+	"fmt"
+	"monkey_cc/ast"
+	"monkey_cc/compiler"
+	"monkey_cc/evaluator"
+	"monkey_cc/lexer"
+	"monkey_cc/object"
+	"monkey_cc/parser"
+	"monkey_cc/vm"
+	"sort"
+	"strings"
+)
+
+// Case 语料中的一段程序与其期望的规范化结果
+type Case struct {
+	Input    string
+	Expected string
+}
+
+// RunEvaluator 使用树遍历求值器执行程序，返回规范化的结果
+func RunEvaluator(input string) (result string) {
+	defer recoverPanic(&result)
+	program, err := parse(input)
+	if err != nil {
+		return err.Error()
+	}
+	evaluated := evaluator.Eval(program, object.NewEnvironment())
+	if errObj, ok := evaluated.(*object.Error); ok {
+		return "error: " + errObj.Message
+	}
+	return Canonical(evaluated)
+}
+
+// RunVM 编译程序并在虚拟机上执行，返回规范化的结果
+// 编译错误与运行时错误不做区分
+func RunVM(input string) (result string) {
+	defer recoverPanic(&result)
+	program, err := parse(input)
+	if err != nil {
+		return err.Error()
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		// 与求值器一致，只比较错误信息，位置由各引擎的测试检查
+		var compileErr *compiler.Error
+		if errors.As(err, &compileErr) {
+			return "error: " + compileErr.Message
+		}
+		return "error: " + err.Error()
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return "error: " + err.Error()
+	}
+	// 求值器中let语句、循环与try语句没有值
+	if len(program.Statements) == 0 || endsWithoutValue(program) {
+		return Canonical(nil)
+	}
+	return Canonical(machine.LastPopped())
+}
+
+// EvaluatorTrace 使用求值器执行程序，返回运行时错误的位置与调用栈
+// 程序没有出错时返回空字符串
+func EvaluatorTrace(input string) string {
+	program, err := parse(input)
+	if err != nil {
+		return err.Error()
+	}
+	errObj, _ := evaluator.Eval(program, object.NewEnvironment()).(*object.Error)
+	return trace(errObj)
+}
+
+// VMTrace 编译程序并在虚拟机上执行，返回运行时错误的位置与调用栈
+// 程序没有出错时返回空字符串
+func VMTrace(input string) string {
+	program, err := parse(input)
+	if err != nil {
+		return err.Error()
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return "compile error: " + err.Error()
+	}
+	var errObj *object.Error
+	errors.As(vm.New(comp.Bytecode()).Run(), &errObj)
+	return trace(errObj)
+}
+
+// 求值器没有操作码，比较时忽略
+func trace(errObj *object.Error) string {
+	if errObj == nil {
+		return ""
+	}
+	withoutOpcode := *errObj
+	withoutOpcode.Opcode = ""
+	return withoutOpcode.StackTrace()
+}
+
+// Canonical 将对象转化为与引擎无关的字符串形式
+// 哈希表按键排序，函数对象不比较具体内容
+func Canonical(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil:
+		return "nil"
+	case *object.Array:
+		elements := make([]string, len(obj.Elements))
+		for i, el := range obj.Elements {
+			elements[i] = Canonical(el)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *object.Hash:
+		pairs := make([]string, 0, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			pairs = append(pairs, Canonical(pair.Key)+": "+Canonical(pair.Value))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *object.Function, *object.CompiledFunction, *object.Closure:
+		return "fn"
+	case *object.BuiltIn:
+		return "builtin"
+	default:
+		return obj.Inspect()
+	}
+}
+
+func parse(input string) (*ast.Program, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parse error: %s", strings.Join(p.Errors(), "; "))
+	}
+	return program, nil
+}
+
+func endsWithoutValue(program *ast.Program) bool {
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.LetStatement, *ast.WhileStatement, *ast.ForStatement, *ast.TryStatement:
+		return true
+	}
+	return false
+}
+
+// 引擎内部的panic同样视为一种结果，使对比可以继续进行
+func recoverPanic(result *string) {
+	if r := recover(); r != nil {
+		*result = fmt.Sprintf("panic: %v", r)
+	}
+}
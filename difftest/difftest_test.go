@@ -0,0 +1,67 @@
+package difftest
+
+import "testing"
+
+func TestCorpus(t *testing.T) {
+	for i, tt := range Corpus {
+		evaluated := RunEvaluator(tt.Input)
+		executed := RunVM(tt.Input)
+		if evaluated != executed {
+			t.Errorf("case %d: engines disagree on %q\nevaluator: %s\nvm:        %s", i, tt.Input, evaluated, executed)
+		}
+		if evaluated != tt.Expected {
+			t.Errorf("case %d: evaluator result of %q\nexpect: %s\nfound:  %s", i, tt.Input, tt.Expected, evaluated)
+		}
+		if executed != tt.Expected {
+			t.Errorf("case %d: vm result of %q\nexpect: %s\nfound:  %s", i, tt.Input, tt.Expected, executed)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 2, "a": [1, {true: if (false) { 1 }}]}`, `{"a": [1, {true: null}], "b": 2}`},
+		{"fn() { 1 }", "fn"},
+		{"puts", "builtin"},
+	}
+	for _, tt := range tests {
+		if result := RunEvaluator(tt.input); result != tt.expected {
+			t.Errorf("RunEvaluator(%q): expected %s, found %s", tt.input, tt.expected, result)
+		}
+		if result := RunVM(tt.input); result != tt.expected {
+			t.Errorf("RunVM(%q): expected %s, found %s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + true", "1:3: type mismatch: INTEGER + BOOLEAN"},
+		{
+			"let add = fn(a, b) {\n  a + b\n};\nadd(1, true);",
+			"2:5: type mismatch: INTEGER + BOOLEAN\n    at add (2:5)\n    at <main> (4:4)",
+		},
+		{
+			"let inner = fn() { len(1) };\nlet outer = fn() { inner() };\nouter()",
+			"1:23: argument type INTEGER to `len` is not supported\n    at inner (1:23)\n    at outer (2:25)\n    at <main> (3:6)",
+		},
+		{
+			"fn(x) { -x }(true)",
+			"1:9: unknown operator: - BOOLEAN\n    at <anonymous> (1:9)\n    at <main> (1:13)",
+		},
+	}
+	for _, tt := range tests {
+		if result := EvaluatorTrace(tt.input); result != tt.expected {
+			t.Errorf("EvaluatorTrace(%q):\nexpect: %s\nfound:  %s", tt.input, tt.expected, result)
+		}
+		if result := VMTrace(tt.input); result != tt.expected {
+			t.Errorf("VMTrace(%q):\nexpect: %s\nfound:  %s", tt.input, tt.expected, result)
+		}
+	}
+}
@@ -2,11 +2,24 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
 	"monkey_cc/ast"
 	"monkey_cc/object"
+	"monkey_cc/token"
+	"strings"
 )
 
+// Eval evaluates the node, an error is attached with the position of
+// the innermost node where it happened
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Position.IsValid() {
+		err.Position = node.Pos()
+	}
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -14,37 +27,72 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Exp, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if interrupts(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.BlockStatement:
 		return evalBlockStatements(node, env)
 	case *ast.LetStatement:
+		if env.IsLocalConst(node.Name.Value) {
+			err := newError("cannot redefine constant %s", node.Name.Value)
+			err.Position = node.Name.Pos()
+			return err
+		}
+		val := Eval(node.Value, env)
+		if interrupts(val) {
+			return val
+		}
+		if node.IsConst() {
+			env.SetConst(node.Name.Value, val)
+		} else {
+			env.Set(node.Name.Value, val)
+		}
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+	case *ast.ThrowStatement:
 		val := Eval(node.Value, env)
-		env.Set(node.Name.Value, val)
+		if interrupts(val) {
+			return val
+		}
+		return object.Throw(val)
+	case *ast.BreakStatement:
+		return object.BREAK
+	case *ast.ContinueStatement:
+		return object.CONTINUE
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if interrupts(right) {
 			return right
 		}
 		return evalPrefixExp(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExp(node, env)
+		}
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if interrupts(left) {
 			return left
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if interrupts(right) {
 			return right
 		}
 		return evalInfixExp(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return evalAssignExp(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.Boolean:
@@ -52,33 +100,33 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Name: node.Name, Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
 		fn := Eval(node.Function, env)
-		if isError(fn) {
+		if interrupts(fn) {
 			return fn
 		}
 		args := evalExps(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && interrupts(args[0]) {
 			return args[0]
 		}
-		return applyFunction(fn, args)
+		return applyFunction(fn, args, node.Pos())
 	case *ast.ArrayLiteral:
 		elements := evalExps(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && interrupts(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if interrupts(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if interrupts(index) {
 			return index
 		}
-		return evalInfixExp("[", left, index)
+		return evalIndexExp(left, index)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -136,17 +184,21 @@ func evalProgram(p *ast.Program, env *object.Environment) object.Object {
 }
 
 // when evaluate ReturnStmt, it won't unpack its value but return the "Return Object"
+// break and continue are passed outwards the same way until they reach the loop
 // for other cases, it returns the value of last sentence
+// an empty block or a block ending with let statement has value Null
+// a block has its own scope, so bindings declared in it are invisible outside
 func evalBlockStatements(block *ast.BlockStatement, env *object.Environment) (result object.Object) {
+	blockEnv := object.NewEnclosedEnvironment(env)
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
-			}
+		result = Eval(stmt, blockEnv)
+		if interrupts(result) {
+			return result
 		}
 	}
+	if result == nil {
+		return object.NULL
+	}
 	return result
 }
 
@@ -155,7 +207,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
 		return builtin
 	}
 	return newError("identifier not found: %s", node.Value)
@@ -167,6 +219,8 @@ func evalPrefixExp(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusOperatorExpression(right)
+	case "~":
+		return evalBitNotOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s %s", operator, right.Type())
 	}
@@ -186,27 +240,38 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: - %s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+}
+
+func evalBitNotOperatorExpression(right object.Object) object.Object {
+	integer, ok := right.(*object.Integer)
+	if !ok {
+		return newError("unknown operator: ~ %s", right.Type())
+	}
+	return &object.Integer{Value: ^integer.Value}
 }
 
 func evalInfixExp(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExp(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExp(operator, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfixExp(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExp(operator, left, right)
-	case left.Type() == object.ARRAY_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIndexExp(left, right)
-	case left.Type() == object.HASH_OBJ:
-		return evalHashIndexExp(left, right)
-	default:
+	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -216,6 +281,10 @@ func evalStringInfixExp(operator string, left, right object.Object) object.Objec
 	switch operator {
 	case "+":
 		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return b2b(leftVal == rightVal)
+	case "!=":
+		return b2b(leftVal != rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -229,10 +298,6 @@ func evalBooleanInfixExp(operator string, left, right object.Object) object.Obje
 		return b2b(leftVal == rightVal)
 	case "!=":
 		return b2b(leftVal != rightVal)
-	case "&&":
-		return b2b(leftVal && rightVal)
-	case "||":
-		return b2b(leftVal || rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -249,29 +314,132 @@ func evalIntegerInfixExp(operator string, left, right object.Object) object.Obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
 	case "<":
 		return b2b(leftVal < rightVal)
 	case ">":
 		return b2b(leftVal > rightVal)
+	case "<=":
+		return b2b(leftVal <= rightVal)
+	case ">=":
+		return b2b(leftVal >= rightVal)
 	case "==":
 		return b2b(leftVal == rightVal)
 	case "!=":
 		return b2b(leftVal != rightVal)
-	case "&&":
-		return b2b((leftVal != 0) && (rightVal != 0))
-	case "||":
-		return b2b((leftVal != 0) || (rightVal != 0))
 	case "&":
 		return &object.Integer{Value: leftVal & rightVal}
 	case "|":
 		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			return &object.Integer{Value: leftVal << rightVal}
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// 至少有一个操作数为浮点数，整数先转化为浮点数再运算
+func evalFloatInfixExp(operator string, left, right object.Object) object.Object {
+	leftVal := floatValue(left)
+	rightVal := floatValue(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return b2b(leftVal < rightVal)
+	case ">":
+		return b2b(leftVal > rightVal)
+	case "<=":
+		return b2b(leftVal <= rightVal)
+	case ">=":
+		return b2b(leftVal >= rightVal)
+	case "==":
+		return b2b(leftVal == rightVal)
+	case "!=":
+		return b2b(leftVal != rightVal)
+	}
+	if left.Type() != right.Type() {
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func floatValue(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
+// && 与 || 采用短路求值，结果已确定时不再对右值求值
+// 结果总是布尔值，真假的判断与if表达式一致
+func evalLogicalExp(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if interrupts(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return object.FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return object.TRUE
+	}
+	right := Eval(node.Right, env)
+	if interrupts(right) {
+		return right
+	}
+	return b2b(isTruthy(right))
+}
+
 func evalIndexExp(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExp(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExp(left, index)
+	case left.Type() == object.EXCEPTION_OBJ && index.Type() == object.STRING_OBJ:
+		return left.(*object.Exception).Field(index.(*object.String).Value)
+	default:
+		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// out of range index results in Null
+func evalArrayIndexExp(left, index object.Object) object.Object {
 	leftVal := left.(*object.Array).Elements
 	indexVal := index.(*object.Integer).Value
 	if indexVal < 0 || indexVal >= int64(len(leftVal)) {
@@ -296,9 +464,89 @@ func evalHashIndexExp(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
+// x op= v is the same as x = x op v, the old value is read before v is evaluated
+// the collection and index of an index assignment are evaluated only once
+func evalAssignExp(node *ast.AssignExpression, env *object.Environment) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			if object.GetBuiltinByName(target.Value) != nil {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("identifier not found: %s", target.Value)
+		}
+		if env.IsConst(target.Value) {
+			err := newError("cannot assign to constant %s", target.Value)
+			err.Position = target.Pos()
+			return err
+		}
+		val := evalAssignValue(node, operator, current, env)
+		if interrupts(val) {
+			return val
+		}
+		env.Assign(target.Value, val)
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if interrupts(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if interrupts(index) {
+			return index
+		}
+		var current object.Object
+		if operator != "" {
+			current = evalIndexExp(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := evalAssignValue(node, operator, current, env)
+		if interrupts(val) {
+			return val
+		}
+		return evalIndexAssign(left, index, val)
+	}
+	return newError("cannot assign to %s", node.Target)
+}
+
+// the value to be assigned, combined with the old value for compound assignment
+func evalAssignValue(node *ast.AssignExpression, operator string, current object.Object, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if interrupts(val) || operator == "" {
+		return val
+	}
+	return evalInfixExp(operator, current, val)
+}
+
+// arrays and hashes are modified in place
+func evalIndexAssign(left, index, val object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return newError("index out of range: %d", i)
+		}
+		elements[i] = val
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+	default:
+		return newError("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+	return val
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if interrupts(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
@@ -310,11 +558,111 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+// a loop has no value, like let statement
+// return and error inside the body stop the loop and are passed outwards
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		cond := Eval(ws.Condition, env)
+		if interrupts(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return nil
+		}
+		if stop, result := evalLoopBody(ws.Body, env); stop {
+			return result
+		}
+	}
+}
+
+// for (x in arr) is the same as iterating idx from 0 while idx < len(arr),
+// binding arr[idx] to x, so that both engines behave the same way
+// x is only visible in the body, and each iteration has its own x
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if interrupts(iterable) {
+		return iterable
+	}
+	if iterable.Type() != object.ARRAY_OBJ {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+	length := object.GetBuiltinByName("len")
+	for idx := int64(0); ; idx++ {
+		n := length.Fn(iterable)
+		if isError(n) {
+			return n
+		}
+		if idx >= n.(*object.Integer).Value {
+			return nil
+		}
+		elem := evalIndexExp(iterable, &object.Integer{Value: idx})
+		if isError(elem) {
+			return elem
+		}
+		iterEnv := object.NewEnclosedEnvironment(env)
+		iterEnv.Set(fs.Variable.Value, elem)
+		if stop, result := evalLoopBody(fs.Body, iterEnv); stop {
+			return result
+		}
+	}
+}
+
+// evaluate the body once, report whether the loop should stop
+// and the value the loop statement should result in
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (bool, object.Object) {
+	result := Eval(body, env)
+	if result == nil {
+		return false, nil
+	}
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return true, nil
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return true, result
+	}
+	return false, nil
+}
+
+// an error in the try block is bound to the catch parameter as an exception,
+// the finally block always runs, and an error or a return, break or continue
+// in it replaces the outcome of the try and catch blocks
+// like loops, try is a statement without value
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(ts.Block, env)
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		catchEnv.Set(ts.Param.Value, &object.Exception{Err: err})
+		result = Eval(ts.Catch, catchEnv)
+	}
+	if ts.Finally != nil {
+		if finally := Eval(ts.Finally, env); interrupts(finally) {
+			return finally
+		}
+	}
+	if interrupts(result) {
+		return result
+	}
+	return nil
+}
+
+// errors and return, break and continue signals stop the enclosing block
+// and every expression they occur in
+func interrupts(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
+
 // evaluate a series of expressions
 func evalExps(exps []ast.Expression, env *object.Environment) (objs []object.Object) {
 	for _, exp := range exps {
 		evaluated := Eval(exp, env)
-		if isError(evaluated) {
+		if interrupts(evaluated) {
 			return []object.Object{evaluated}
 		}
 		objs = append(objs, evaluated)
@@ -326,13 +674,20 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// an error leaving the body of fn records the call in its stack trace
+func applyFunction(fn object.Object, args []object.Object, call token.Position) object.Object {
 	switch fn := fn.(type) {
 	case *object.BuiltIn:
 		return fn.Fn(args...)
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
+		if err, ok := evaluated.(*object.Error); ok {
+			err.Frames = append(err.Frames, object.Frame{Function: fn.Name, Call: call})
+		}
 		return unwrapReturnValue(evaluated)
 	default:
 		return newError("not a function: %s", fn.Type())
@@ -360,7 +715,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 
 	for keyNode, valueNode := range node.Pairs {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if interrupts(key) {
 			return key
 		}
 		hashKey, ok := key.(object.Hashable)
@@ -368,7 +723,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("unusable as hash key: %s", key.Type())
 		}
 		value := Eval(valueNode, env)
-		if isError(value) {
+		if interrupts(value) {
 			return value
 		}
 		hashed := hashKey.HashKey()
@@ -4,6 +4,7 @@ import (
 	"monkey_cc/lexer"
 	"monkey_cc/object"
 	"monkey_cc/parser"
+	"monkey_cc/token"
 	"testing"
 )
 
@@ -28,6 +29,19 @@ func assertInteger(t *testing.T, obj object.Object, expect int64) bool {
 	return true
 }
 
+func assertFloat(t *testing.T, obj object.Object, expect float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Fatalf("obj is not *object.Float")
+		return false
+	}
+	if result.Value != expect {
+		t.Fatalf("obj: expect %g, found %g", expect, result.Value)
+		return false
+	}
+	return true
+}
+
 func assertBoolean(t *testing.T, obj object.Object, expect bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -77,6 +91,15 @@ func TestEvalInteger(t *testing.T) {
 		{"5 * 2 + 10", 20},
 		{"2 * (5 + 10)", 30},
 		{"5 + 10 / 2", 10},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"1 | 2 & 3", 3},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
 	}
 
 	for _, tt := range tests {
@@ -87,6 +110,42 @@ func TestEvalInteger(t *testing.T) {
 	}
 }
 
+func TestEvalFloat(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect float64
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999},
+		{"float(2)", 2},
+		{`float("1.25")`, 1.25},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		assertFloat(t, evaluated, tt.expect)
+	}
+
+	comparisons := []struct {
+		input  string
+		expect bool
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+	}
+	for _, tt := range comparisons {
+		evaluated := testEval(tt.input)
+		assertBoolean(t, evaluated, tt.expect)
+	}
+}
+
 func TestEvalBoolean(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -106,6 +165,19 @@ func TestEvalBoolean(t *testing.T) {
 		{"true != false", true},
 		{"true && false", false},
 		{"true || false", true},
+		{"false || false", false},
+		{"false && len(1)", false},
+		{"true || len(1)", true},
+		{"1 < 2 && 2 < 3", true},
+		{"if (1 > 2) { 1 } || 5", true},
+		{`"monkey" == "monkey"`, true},
+		{`"monkey" == "banana"`, false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{`"monkey" != "banana"`, true},
 	}
 
 	for _, tt := range tests {
@@ -236,6 +308,198 @@ func TestReturnStmts(t *testing.T) {
 	}
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"while (false) { 1 }", nil},
+		{"while (true) { break; } 5", 5},
+		{"for (x in [1, 2, 3]) { x }", nil},
+		{"let last = 0; for (x in [1, 2, 3]) { last = x; if (x == 3) { break; } } last", 3},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x > 1) { return x * 10; } } }; f()", 20},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x < 3) { continue; } return x; } }; f()", 3},
+		{"let f = fn() { while (true) { if (true) { return 7; } } }; f()", 7},
+		{"let f = fn() { for (x in []) { return 1; } }; f()", object.NULL},
+		// break只跳出最内层的循环
+		{"let f = fn() { for (x in [1, 2]) { let last = 0; for (y in [3, 4]) { last = y; break; } if (x == 2) { return last; } } }; f()", 3},
+		// 表达式中的break、continue与return中止整个表达式，不会作为值被使用
+		{"let n = 0; while (n < 3) { n += 1; let y = if (true) { break; }; } n", 1},
+		{"let r = 0; while (true) { r = 10 + if (true) { break; } else { 1 }; } r", 0},
+		{"let s = 0; for (c in [1, 2, 3]) { s += len([if (c == 2) { continue; }]) * c; } s", 4},
+		{"let f = fn() { let x = 1 + if (true) { return 5; }; x }; f()", 5},
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case nil:
+			if evaluated != nil {
+				t.Errorf("%q: expected no value, found %s", tt.input, evaluated.Inspect())
+			}
+		case int:
+			assertInteger(t, evaluated, int64(expect))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected error, found %T (%+v)", tt.input, evaluated, evaluated)
+			} else if err.Message != expect {
+				t.Errorf("%q: expected error %q, found %q", tt.input, expect, err.Message)
+			}
+		default:
+			assertNull(t, evaluated)
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, nil},
+		{`let r = 0; try { throw 5; r = 1; } catch (e) { r = e["value"]; } r`, 5},
+		{`let r = 0; try { 1 + true } catch (e) { r = e["message"]; } r`, "type mismatch: INTEGER + BOOLEAN"},
+		{`let r = 0; try { r = 1 } finally { r += 1 } r`, 2},
+		{"let f = fn() { try { return 1; } finally { return 2; } }; f()", 2},
+		{"let f = fn() { try { throw 1; } finally { return 2; } }; f()", 2},
+		{"let n = 0; while (true) { try { n += 1; break; } finally { n += 10; } } n", 11},
+		{"let f = fn(x) { throw x * 2 }; let r = 0; try { f(3) } catch (e) { r = e[\"value\"] } r", 6},
+		{`throw "oops"`, errorMessage("oops")},
+		{`try { throw "a" } finally { 1 }`, errorMessage("a")},
+		{`try { throw "a" } catch (e) { throw e }`, errorMessage("a")},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case nil:
+			if evaluated != nil {
+				t.Errorf("%q: expected no value, found %s", tt.input, evaluated.Inspect())
+			}
+		case int:
+			assertInteger(t, evaluated, int64(expect))
+		case string:
+			assertString(t, evaluated, expect)
+		case errorMessage:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected error, found %T (%+v)", tt.input, evaluated, evaluated)
+			} else if err.Message != string(expect) {
+				t.Errorf("%q: expected error %q, found %q", tt.input, expect, err.Message)
+			}
+		}
+	}
+}
+
+// 期望的错误信息，与期望的字符串值区分
+type errorMessage string
+
+func TestAssignExp(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 2; x -= 3; x *= 4; x /= 6; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; let s = 0; while (i < 5) { i += 1; s += i; } s", 15},
+		// 修改定义变量的作用域，而不是在函数中重新定义
+		{"let x = 1; let f = fn() { x = 2; }; f(); x", 2},
+		{"let f = fn(x) { let g = fn() { x += 1 }; g(); x }; f(1)", 2},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[2] *= 2; a[2]", 6},
+		{"let a = [1, 2]; let b = a; b[0] = 9; a[0]", 9},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 5; h["a"] + h["b"]`, 7},
+		{"y = 1", "identifier not found: y"},
+		{"len = 1", "cannot assign to builtin len"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{`let s = "ab"; s[0] = "c"`, "index assignment not supported: STRING[INTEGER]"},
+		{"let h = {}; h[fn() {}] = 1", "unusable as hash key: FUNCTION"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			assertInteger(t, evaluated, int64(expect))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected error, found %T (%+v)", tt.input, evaluated, evaluated)
+			} else if err.Message != expect {
+				t.Errorf("%q: expected error %q, found %q", tt.input, expect, err.Message)
+			}
+		}
+	}
+}
+
+func TestConstStmt(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"const x = 5; x", 5},
+		{"const x = 1; let f = fn() { let x = 2; x += 1; x }; f() + x", 4},
+		{"const x = 1; let f = fn(x) { x = 5; x }; f(0)", 5},
+		{"const a = [1]; a[0] = 2; a[0]", 2},
+		{"let x = 1; const x = 2; x", 2},
+		{"const x = 1; x = 2", "1:14: cannot assign to constant x"},
+		{"const x = 1; let f = fn() { x += 1 }; f()", "1:29: cannot assign to constant x"},
+		{"const x = 1;\nlet x = 2;", "2:5: cannot redefine constant x"},
+		{"const x = 1; const x = 2;", "1:20: cannot redefine constant x"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			assertInteger(t, evaluated, int64(expect))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected error, found %T (%+v)", tt.input, evaluated, evaluated)
+			} else if msg := err.Position.String() + ": " + err.Message; msg != expect {
+				t.Errorf("%q: expected error %q, found %q", tt.input, expect, msg)
+			}
+		}
+	}
+}
+
+func TestBlockScope(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"if (true) { let x = 1; } x", "identifier not found: x"},
+		{"let x = 1; if (true) { let x = 2; x }", 2},
+		{"let x = 1; if (true) { let x = 2; } x", 1},
+		{"let x = 1; if (true) { x = 2; } x", 2},
+		{"for (x in [1]) { x } x", "identifier not found: x"},
+		{"let i = 0; while (i < 3) { let j = i; i += 1; } j", "identifier not found: j"},
+		{"let f = fn(x) { if (x > 0) { let x = x * 10; return x; } x }; f(1) + f(-1)", 9},
+		{"let f = fn() { let a = 1; if (true) { let b = 2; fn() { a + b } } }; f()()", 3},
+		// 每次迭代的循环变量是独立的
+		{"let f = fn() { let g = 0; for (x in [1, 2]) { if (x == 1) { g = fn() { x }; } } g() }; f()", 1},
+		{"const c = 1; if (true) { const c = 2; c }", 2},
+		{"const x = [1, 2]; let s = 0; for (x in x) { s += x; } s", 3},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			assertInteger(t, evaluated, int64(expect))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected error, found %T (%+v)", tt.input, evaluated, evaluated)
+			} else if err.Message != expect {
+				t.Errorf("%q: expected error %q, found %q", tt.input, expect, err.Message)
+			}
+		}
+	}
+}
+
 func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -257,6 +521,22 @@ func TestErrorHandling(t *testing.T) {
 			"if (10 > 1) { true + false; }",
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
+		{
+			"fn(a, b) { a + b; }(1);",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"1 % 0",
+			"modulo by zero",
+		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"~true",
+			"unknown operator: ~ BOOLEAN",
+		},
 	}
 
 	for i, tt := range tests {
@@ -271,6 +551,29 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorTrace(t *testing.T) {
+	input := "let add = fn(a, b) { a + b };\nlet call = fn() { add(1, true) };\ncall();"
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("evaluated is not *object.Error")
+	}
+	if errObj.Position.String() != "1:24" {
+		t.Errorf("expected position 1:24, found %s", errObj.Position)
+	}
+	expected := []object.Frame{
+		{Function: "add", Call: token.Position{Offset: 51, Line: 2, Column: 22}},
+		{Function: "call", Call: token.Position{Offset: 68, Line: 3, Column: 5}},
+	}
+	if len(errObj.Frames) != len(expected) {
+		t.Fatalf("expected %d frames, found %d", len(expected), len(errObj.Frames))
+	}
+	for i, frame := range expected {
+		if errObj.Frames[i] != frame {
+			t.Errorf("frame %d: expected %v, found %v", i, frame, errObj.Frames[i])
+		}
+	}
+}
+
 func TestLetStmt(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -311,6 +614,9 @@ func TestFunctionApplication(t *testing.T) {
 		expect int64
 	}{
 		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let double = fn(x) { x * 2; }; double(5);", 10},
+		{"let add = fn(x, y) { return x + y; }; add(5, add(5, 5));", 15},
+		{"fn(x) { x; }(5)", 5},
 	}
 	for _, tt := range tests {
 		assertInteger(t, testEval(tt.input), tt.expect)
@@ -324,6 +630,11 @@ func TestBuiltInFunction(t *testing.T) {
 	}{
 		{`len("")`, 0},
 		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`int(3.9)`, 3},
+		{`int(-3.9)`, -3},
+		{`int("42")`, 42},
 	}
 
 	for _, tt := range tests {
@@ -1,33 +1,113 @@
 package lexer
 
-import "monkey_cc/token"
+import (
+	"fmt"
+	"monkey_cc/diagnostic"
+	"monkey_cc/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
-	input string
-	// pos 指向当前读取的字符 pos == -1 表示尚未开始读取
+	input    string
+	filename string
+	// pos 指向当前读取的字符的首字节 pos == -1 表示尚未开始读取
 	pos int
+	// next 为下一个字符的首字节
+	next int
+	// line与column为pos所指字符的行号与列号，列号按Unicode字符计数
+	line   int
+	column int
+	errors []diagnostic.Diagnostic
+	// 为true时注释作为COMMENT词法单元返回，否则跳过注释
+	emitComments bool
+}
+
+// 解码offset处的字符，返回字符与其UTF-8编码的字节数
+// offset超出输入时返回0
+func (l *Lexer) decodeChar(offset int) (rune, int) {
+	if offset >= len(l.input) {
+		return 0, 0
+	}
+	return utf8.DecodeRuneInString(l.input[offset:])
 }
 
 // 读取下一个字符，但是指针不向前移动
 // 当下一个字符不存在时，返回0
-func (l *Lexer) peekChar() byte {
-	if l.pos == len(l.input)-1 {
-		return 0
-	} else {
-		return l.input[l.pos+1]
-	}
+func (l *Lexer) peekChar() rune {
+	ch, _ := l.decodeChar(l.next)
+	return ch
+}
+
+// 读取下一个字符之后的字符，但是指针不向前移动
+// 当该字符不存在时，返回0
+func (l *Lexer) peekSecondChar() rune {
+	_, size := l.decodeChar(l.next)
+	ch, _ := l.decodeChar(l.next + size)
+	return ch
 }
 
 // 读取下一个字符，同时指针前移
 // 当下一个字符不存在时，返回0，且指针不会移动
-func (l *Lexer) nextChar() byte {
-	next := l.peekChar()
-	if next == 0 {
+func (l *Lexer) nextChar() rune {
+	ch, size := l.decodeChar(l.next)
+	if size == 0 {
 		return 0
-	} else {
-		l.pos++
-		return next
 	}
+	l.line, l.column = l.peekLineColumn()
+	l.pos = l.next
+	l.next += size
+	return ch
+}
+
+// 下一个字符的行号与列号
+func (l *Lexer) peekLineColumn() (int, int) {
+	if l.pos >= 0 && l.input[l.pos] == '\n' {
+		return l.line + 1, 1
+	}
+	return l.line, l.column + 1
+}
+
+// 下一个字符在源码中的位置
+func (l *Lexer) peekPosition() token.Position {
+	line, column := l.peekLineColumn()
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.next,
+		Line:     line,
+		Column:   column,
+	}
+}
+
+// 当前字符在源码中的位置
+func (l *Lexer) position() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.pos,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// 记录一个错误，出错区间为[start, 下一个字符)
+func (l *Lexer) addError(code diagnostic.Code, start token.Position, hint string, format string, a ...interface{}) {
+	l.addSpanError(code, diagnostic.Span{Start: start, End: l.peekPosition()}, hint, format, a...)
+}
+
+func (l *Lexer) addSpanError(code diagnostic.Code, span diagnostic.Span, hint string, format string, a ...interface{}) {
+	l.errors = append(l.errors, diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Span:     span,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
+// Diagnostics 返回词法分析中产生的诊断信息
+func (l *Lexer) Diagnostics() []diagnostic.Diagnostic {
+	return l.errors
 }
 
 // 消耗输入中的空白字符，包括空格，制表符，换行符
@@ -52,33 +132,281 @@ func (l *Lexer) readIdentifier() string {
 		}
 		l.nextChar()
 	}
-	return l.input[begin : l.pos+1]
+	return l.input[begin:l.next]
 }
 
-// 读取数字
-func (l *Lexer) readInteger() string {
+// 整数字面量的进制前缀
+var numberBases = map[rune]struct {
+	base int
+	name string
+}{
+	'x': {16, "hexadecimal"},
+	'X': {16, "hexadecimal"},
+	'o': {8, "octal"},
+	'O': {8, "octal"},
+	'b': {2, "binary"},
+	'B': {2, "binary"},
+}
+
+// 读取数字字面量
+// 整数支持0x、0o、0b前缀，十进制数可以带有小数部分与指数部分，此时为浮点数
+// 各部分的数字之间都可以用"_"分隔
+// 调用时l.pos指向第一个数字，字面量不合法时记录错误并返回ILLEGAL
+func (l *Lexer) readNumber() *token.Token {
+	start := l.position()
 	begin := l.pos
+	if l.input[l.pos] == '0' {
+		if b, ok := numberBases[l.peekChar()]; ok {
+			l.nextChar()
+			l.skipAlphanumeric()
+			literal := l.input[begin:l.next]
+			if !l.checkDigits(literal, 2, b.base, b.name, start) {
+				return token.New(token.ILLEGAL, literal)
+			}
+			return token.New(token.INT, literal)
+		}
+	}
+	// 紧随其后的字母、数字与"_"都属于该字面量，使"12ab"这类错误能够作为整体报告
+	// "."只有在后面是数字时才属于字面量，指数的符号只能紧跟在"e"之后
 	for {
 		ch := l.peekChar()
-		if !isDigit(ch) {
-			break
+		switch {
+		case isLetter(ch) || isDigit(ch):
+		case ch == '.' && isDigit(l.peekSecondChar()) && !strings.ContainsAny(l.input[begin:l.next], ".eE"):
+		case (ch == '+' || ch == '-') && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E'):
+		default:
+			literal := l.input[begin:l.next]
+			if strings.ContainsAny(literal, ".eE") {
+				if !l.checkDigits(literal, 0, 10, "float", start) {
+					return token.New(token.ILLEGAL, literal)
+				}
+				return token.New(token.FLOAT, literal)
+			}
+			if !l.checkDigits(literal, 0, 10, "decimal", start) {
+				return token.New(token.ILLEGAL, literal)
+			}
+			if len(literal) > 1 && literal[0] == '0' {
+				l.addError(diagnostic.InvalidNumber, start, "use the 0o prefix for octal literals",
+					"leading zeros are not allowed in decimal literals")
+				return token.New(token.ILLEGAL, literal)
+			}
+			return token.New(token.INT, literal)
 		}
 		l.nextChar()
 	}
-	return l.input[begin : l.pos+1]
 }
 
+func (l *Lexer) skipAlphanumeric() {
+	for ch := l.peekChar(); isLetter(ch) || isDigit(ch); ch = l.peekChar() {
+		l.nextChar()
+	}
+}
+
+// 检查数字字面量中前缀之后的部分，start为字面量的起始位置
+// "_"只能出现在两个数字之间，十进制数中还可以出现"."与指数
+func (l *Lexer) checkDigits(literal string, prefix, base int, name string, start token.Position) bool {
+	digits := []rune(literal[prefix:])
+	if len(digits) == 0 {
+		l.addError(diagnostic.InvalidNumber, start, "", "%s literal has no digits", name)
+		return false
+	}
+	// offsets[i]为第i个字符相对于字面量开头的字节偏移
+	offsets := make([]int, len(digits)+1)
+	offsets[0] = prefix
+	for i, ch := range digits {
+		offsets[i+1] = offsets[i] + utf8.RuneLen(ch)
+	}
+	charSpan := func(i int) diagnostic.Span {
+		begin, end := start, start
+		begin.Column += prefix + i
+		begin.Offset += offsets[i]
+		end.Column += prefix + i + 1
+		end.Offset += offsets[i+1]
+		return diagnostic.Span{Start: begin, End: end}
+	}
+	isDigitOf := func(i int) bool {
+		return i >= 0 && i < len(digits) && digitValue(digits[i]) < base
+	}
+
+	exponent := false
+	for i := 0; i < len(digits); i++ {
+		switch ch := digits[i]; {
+		case ch == '_':
+			// 连续的"_"在第二个处报告
+			if !isDigitOf(i-1) || !isDigitOf(i+1) && (i+1 == len(digits) || digits[i+1] != '_') {
+				l.addSpanError(diagnostic.InvalidNumber, charSpan(i), "", "`_` must separate successive digits")
+				return false
+			}
+		case base == 10 && ch == '.':
+		case base == 10 && (ch == 'e' || ch == 'E') && !exponent:
+			exponent = true
+			e := i
+			if i+1 < len(digits) && (digits[i+1] == '+' || digits[i+1] == '-') {
+				i++
+			}
+			if !isDigitOf(i + 1) {
+				l.addSpanError(diagnostic.InvalidNumber, charSpan(e), "", "exponent has no digits")
+				return false
+			}
+		case digitValue(ch) >= base:
+			l.addSpanError(diagnostic.InvalidNumber, charSpan(i), "", "invalid digit %q in %s literal", ch, name)
+			return false
+		}
+	}
+	return true
+}
+
+// 读取双引号字符串，返回转义后的值
+// 调用时l.pos指向开头的'"'
 func (l *Lexer) readString() string {
-	// 跳过‘”’，需要将pos+1
-	begin := l.pos + 1
-	for l.nextChar() != '"' {
+	start := l.position()
+	var out strings.Builder
+	for {
+		switch ch := l.nextChar(); ch {
+		case 0:
+			l.addError(diagnostic.UnterminatedString, start, "add a closing `\"`", "unterminated string")
+			return out.String()
+		case '"':
+			return out.String()
+		case '\\':
+			l.readEscape(&out)
+		default:
+			// 直接复制源码中的字节，保留非法的UTF-8编码
+			out.WriteString(l.input[l.pos:l.next])
+		}
+	}
+}
+
+// 读取转义序列并写入out，调用时l.pos指向'\'
+func (l *Lexer) readEscape(out *strings.Builder) {
+	start := l.position()
+	switch ch := l.peekChar(); ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '"':
+		out.WriteByte('"')
+	case '\\':
+		out.WriteByte('\\')
+	case 'u':
+		l.nextChar()
+		l.readUnicodeEscape(out, start)
+		return
+	case 0:
+		// 由readString报告字符串未闭合
+		return
+	default:
+		l.nextChar()
+		l.addError(diagnostic.InvalidEscape, start, `supported escapes are \n, \t, \r, \", \\ and \u{...}`,
+			"unknown escape sequence \\%c", ch)
+		return
 	}
-	return l.input[begin:l.pos]
+	l.nextChar()
+}
+
+// 读取形如"\u{1F600}"的转义序列，调用时l.pos指向'u'
+// 花括号中为1至6位十六进制数，且必须是合法的Unicode码点
+func (l *Lexer) readUnicodeEscape(out *strings.Builder, start token.Position) {
+	const hint = "unicode escapes are written as \\u{XXXX}"
+	if l.peekChar() != '{' {
+		l.addError(diagnostic.InvalidEscape, start, hint, "missing `{` in unicode escape")
+		return
+	}
+	l.nextChar()
+	begin := l.next
+	for isHexDigit(l.peekChar()) {
+		l.nextChar()
+	}
+	digits := l.input[begin:l.next]
+	if l.peekChar() != '}' {
+		l.addError(diagnostic.InvalidEscape, start, hint, "missing `}` in unicode escape")
+		return
+	}
+	l.nextChar()
+	if len(digits) == 0 || len(digits) > 6 {
+		l.addError(diagnostic.InvalidEscape, start, hint, "unicode escape must have 1 to 6 hex digits")
+		return
+	}
+	var r rune
+	for i := 0; i < len(digits); i++ {
+		r = r<<4 | rune(digitValue(rune(digits[i])))
+	}
+	if !utf8.ValidRune(r) {
+		l.addError(diagnostic.InvalidEscape, start, "", "invalid unicode code point %s", strings.ToUpper(digits))
+		return
+	}
+	out.WriteRune(r)
+}
+
+// 读取反引号包围的原始字符串，其中没有转义序列，可以跨越多行
+// 调用时l.pos指向开头的'`'
+func (l *Lexer) readRawString() string {
+	start := l.position()
+	begin := l.next
+	for {
+		switch l.nextChar() {
+		case 0:
+			l.addError(diagnostic.UnterminatedString, start, "add a closing \"`\"", "unterminated raw string")
+			return l.input[begin:]
+		case '`':
+			return l.input[begin:l.pos]
+		}
+	}
+}
+
+// 读取"//"开始的行注释，不包括行尾的换行符
+// 调用时l.pos指向第一个'/'
+func (l *Lexer) readLineComment() string {
+	begin := l.pos
+	for ch := l.peekChar(); ch != '\n' && ch != 0; ch = l.peekChar() {
+		l.nextChar()
+	}
+	return l.input[begin:l.next]
+}
+
+// 读取"/*"开始的块注释，块注释可以嵌套
+// 调用时l.pos指向'/'，下一个字符为'*'
+func (l *Lexer) readBlockComment() string {
+	start := l.position()
+	begin := l.pos
+	l.nextChar()
+	depth := 1
+	for depth > 0 {
+		switch ch := l.nextChar(); {
+		case ch == 0:
+			l.addError(diagnostic.UnterminatedComment, start, "add a closing `*/`", "unterminated block comment")
+			return l.input[begin:]
+		case ch == '/' && l.peekChar() == '*':
+			l.nextChar()
+			depth++
+		case ch == '*' && l.peekChar() == '/':
+			l.nextChar()
+			depth--
+		}
+	}
+	return l.input[begin:l.next]
 }
 
 // NextToken 读取下一个词法单元，同时指针前移
+// 词法单元的位置为其第一个字符的位置
 func (l *Lexer) NextToken() *token.Token {
-	l.consumeSpaces()
+	for {
+		l.consumeSpaces()
+		pos := l.peekPosition()
+		tok := l.readToken()
+		if tok.Type == token.COMMENT && !l.emitComments {
+			continue
+		}
+		tok.Pos = pos
+		tok.End = l.peekPosition()
+		return tok
+	}
+}
+
+func (l *Lexer) readToken() *token.Token {
 	ch := l.nextChar()
 	switch ch {
 	case '=':
@@ -89,8 +417,16 @@ func (l *Lexer) NextToken() *token.Token {
 			return token.New(token.ASSIGN, "=")
 		}
 	case '+':
+		if l.peekChar() == '=' {
+			l.nextChar()
+			return token.New(token.PLUS_ASSIGN, "+=")
+		}
 		return token.New(token.PLUS, "+")
 	case '-':
+		if l.peekChar() == '=' {
+			l.nextChar()
+			return token.New(token.MINUS_ASSIGN, "-=")
+		}
 		return token.New(token.MINUS, "-")
 	case '!':
 		if l.peekChar() == '=' {
@@ -100,13 +436,51 @@ func (l *Lexer) NextToken() *token.Token {
 			return token.New(token.BANG, "!")
 		}
 	case '*':
+		if l.peekChar() == '=' {
+			l.nextChar()
+			return token.New(token.ASTERISK_ASSIGN, "*=")
+		}
 		return token.New(token.ASTERISK, "*")
 	case '/':
-		return token.New(token.SLASH, "/")
+		switch l.peekChar() {
+		case '/':
+			return token.New(token.COMMENT, l.readLineComment())
+		case '*':
+			return token.New(token.COMMENT, l.readBlockComment())
+		case '=':
+			l.nextChar()
+			return token.New(token.SLASH_ASSIGN, "/=")
+		default:
+			return token.New(token.SLASH, "/")
+		}
+	case '%':
+		return token.New(token.PERCENT, "%")
+	case '^':
+		return token.New(token.BIT_XOR, "^")
+	case '~':
+		return token.New(token.BIT_NOT, "~")
 	case '<':
-		return token.New(token.LT, "<")
+		switch l.peekChar() {
+		case '=':
+			l.nextChar()
+			return token.New(token.LT_EQ, "<=")
+		case '<':
+			l.nextChar()
+			return token.New(token.SHL, "<<")
+		default:
+			return token.New(token.LT, "<")
+		}
 	case '>':
-		return token.New(token.GT, ">")
+		switch l.peekChar() {
+		case '=':
+			l.nextChar()
+			return token.New(token.GT_EQ, ">=")
+		case '>':
+			l.nextChar()
+			return token.New(token.SHR, ">>")
+		default:
+			return token.New(token.GT, ">")
+		}
 	case ';':
 		return token.New(token.SEMICOLON, ";")
 	case ',':
@@ -142,6 +516,9 @@ func (l *Lexer) NextToken() *token.Token {
 	case '"':
 		literal := l.readString()
 		return token.New(token.STRING, literal)
+	case '`':
+		literal := l.readRawString()
+		return token.New(token.STRING, literal)
 	case 0:
 		return token.New(token.EOF, "")
 	default:
@@ -149,25 +526,59 @@ func (l *Lexer) NextToken() *token.Token {
 			literal := l.readIdentifier()
 			return token.New(token.LookupIdent(literal), literal)
 		} else if isDigit(ch) {
-			literal := l.readInteger()
-			return token.New(token.INT, literal)
+			return l.readNumber()
 		} else {
-			return token.New(token.ILLEGAL, "")
+			l.addError(diagnostic.IllegalCharacter, l.position(), "", "illegal character %q", l.input[l.pos:l.next])
+			return token.New(token.ILLEGAL, l.input[l.pos:l.next])
 		}
 	}
 }
 
 func New(s string) *Lexer {
 	return &Lexer{
-		input: s,
-		pos:   -1,
+		input:  s,
+		pos:    -1,
+		line:   1,
+		column: 0,
 	}
 }
 
-func isLetter(ch byte) bool {
-	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
+// NewWithFilename 创建词法分析器，产生的位置信息中包含文件名
+func NewWithFilename(filename, s string) *Lexer {
+	l := New(s)
+	l.filename = filename
+	return l
+}
+
+// EmitComments 设置是否将注释作为COMMENT词法单元返回，便于格式化工具保留注释
+// 默认跳过注释
+func (l *Lexer) EmitComments(emit bool) {
+	l.emitComments = emit
+}
+
+// 标识符可以包含任意Unicode字母
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return ch >= '0' && ch <= '9'
 }
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+}
+
+// 数字字符的值，非数字字符返回一个大于任何进制的值
+func digitValue(ch rune) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'z':
+		return int(ch-'a') + 10
+	case ch >= 'A' && ch <= 'Z':
+		return int(ch-'A') + 10
+	default:
+		return 36
+	}
+}
@@ -13,7 +13,7 @@ func TestNextToken(t *testing.T) {
 	}
 
 	symbols := `=+(){},;
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 	"Hello, world!";
 	[1, 2];
@@ -194,6 +194,57 @@ func TestNextToken(t *testing.T) {
 		{token.RBRACE, "}"},
 	}
 
+	operators := `a % b <= c >= d << 1 >> 2 ^ ~e < f > g`
+	operatorsExpect := []Expect{
+		{token.IDENT, "a"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "b"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "c"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "d"},
+		{token.SHL, "<<"},
+		{token.INT, "1"},
+		{token.SHR, ">>"},
+		{token.INT, "2"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.IDENT, "e"},
+		{token.LT, "<"},
+		{token.IDENT, "f"},
+		{token.GT, ">"},
+		{token.IDENT, "g"},
+		{token.EOF, ""},
+	}
+
+	assignments := `x += 1; x -= a[0]; x *= 2; x /= 3; x = -1`
+	assignmentsExpect := []Expect{
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.IDENT, "a"},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.EOF, ""},
+	}
+
 	tests := []struct {
 		input  string
 		expect []Expect
@@ -201,6 +252,8 @@ func TestNextToken(t *testing.T) {
 		{input: symbols, expect: symbolsExpect},
 		{input: basic, expect: basicExpect},
 		{input: andOr, expect: andOrExpect},
+		{input: operators, expect: operatorsExpect},
+		{input: assignments, expect: assignmentsExpect},
 	}
 
 	for i, test := range tests {
@@ -218,3 +271,279 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "let x = 5;\n  x + 10;\n\"str\""
+	expect := []struct {
+		expectedType token.TokenType
+		line         int
+		column       int
+		offset       int
+	}{
+		{token.LET, 1, 1, 0},
+		{token.IDENT, 1, 5, 4},
+		{token.ASSIGN, 1, 7, 6},
+		{token.INT, 1, 9, 8},
+		{token.SEMICOLON, 1, 10, 9},
+		{token.IDENT, 2, 3, 13},
+		{token.PLUS, 2, 5, 15},
+		{token.INT, 2, 7, 17},
+		{token.SEMICOLON, 2, 9, 19},
+		{token.STRING, 3, 1, 21},
+		{token.EOF, 3, 6, 26},
+	}
+	l := NewWithFilename("test.mk", input)
+	for i, e := range expect {
+		tok := l.NextToken()
+		if tok.Type != e.expectedType {
+			t.Fatalf("token %d: expected type %s, found %s", i, e.expectedType, tok.Type)
+		}
+		if tok.Pos.Line != e.line || tok.Pos.Column != e.column || tok.Pos.Offset != e.offset {
+			t.Errorf("token %d (%s): expected %d:%d (offset %d), found %d:%d (offset %d)",
+				i, tok.Literal, e.line, e.column, e.offset, tok.Pos.Line, tok.Pos.Column, tok.Pos.Offset)
+		}
+		if tok.Pos.Filename != "test.mk" {
+			t.Errorf("token %d: expected filename test.mk, found %s", i, tok.Pos.Filename)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"tab\there"`, "tab\there"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\r"`, "\r"},
+		{`"\u{41}\u{3b1}\u{1F600}"`, "Aα😀"},
+		{"`raw \\n \"string\"`", `raw \n "string"`},
+		{"`multi\nline`", "multi\nline"},
+	}
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Fatalf("case %d: expected STRING, found %s", i, tok.Type)
+		}
+		if tok.Literal != tt.expect {
+			t.Errorf("case %d: expected %q, found %q", i, tt.expect, tok.Literal)
+		}
+		if len(l.Diagnostics()) != 0 {
+			t.Errorf("case %d: unexpected errors: %v", i, l.Diagnostics())
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("case %d: expected EOF after string, found %s", i, next.Type)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		// 错误的起止位置与信息
+		start  string
+		end    string
+		errMsg string
+	}{
+		{`"a\qb"`, "1:3", "1:5", `unknown escape sequence \q`},
+		{`"\u41"`, "1:2", "1:4", "missing `{` in unicode escape"},
+		{`"\u{41"`, "1:2", "1:7", "missing `}` in unicode escape"},
+		{`"\u{}"`, "1:2", "1:6", "unicode escape must have 1 to 6 hex digits"},
+		{`"\u{D800}"`, "1:2", "1:10", "invalid unicode code point D800"},
+		{`"abc`, "1:1", "1:5", "unterminated string"},
+		{"x\n`abc", "2:1", "2:5", "unterminated raw string"},
+	}
+	for i, tt := range tests {
+		l := New(tt.input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+		errors := l.Diagnostics()
+		if len(errors) != 1 {
+			t.Fatalf("case %d: expected 1 error, found %d: %v", i, len(errors), errors)
+		}
+		d := errors[0]
+		if d.Message != tt.errMsg {
+			t.Errorf("case %d: expected message %q, found %q", i, tt.errMsg, d.Message)
+		}
+		if d.Span.Start.String() != tt.start || d.Span.End.String() != tt.end {
+			t.Errorf("case %d: expected span %s-%s, found %s-%s", i, tt.start, tt.end, d.Span.Start, d.Span.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing
+/* block
+   comment */ x / /* nested /* inner */ still comment */ 2;`
+	expect := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// leading comment"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// trailing"},
+		{token.COMMENT, "/* block\n   comment */"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.COMMENT, "/* nested /* inner */ still comment */"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	// 默认跳过注释
+	l := New(input)
+	for i, e := range expect {
+		if e.expectedType == token.COMMENT {
+			continue
+		}
+		tok := l.NextToken()
+		if tok.Type != e.expectedType || tok.Literal != e.expectedLiteral {
+			t.Fatalf("token %d: expected %s %q, found %s %q", i, e.expectedType, e.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	l = New(input)
+	l.EmitComments(true)
+	for i, e := range expect {
+		tok := l.NextToken()
+		if tok.Type != e.expectedType || tok.Literal != e.expectedLiteral {
+			t.Fatalf("token %d: expected %s %q, found %s %q", i, e.expectedType, e.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+	if len(l.Diagnostics()) != 0 {
+		t.Errorf("unexpected errors: %v", l.Diagnostics())
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	l := New("1 /* open /* inner */\n2")
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+	errors := l.Diagnostics()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, found %d: %v", len(errors), errors)
+	}
+	if errors[0].Message != "unterminated block comment" || errors[0].Span.Start.String() != "1:3" {
+		t.Errorf("wrong error: %s", errors[0])
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let 名字 = \"héllo\";\nπ_2 @ ü€"
+	expect := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		line            int
+		column          int
+		offset          int
+	}{
+		{token.LET, "let", 1, 1, 0},
+		{token.IDENT, "名字", 1, 5, 4},
+		{token.ASSIGN, "=", 1, 8, 11},
+		{token.STRING, "héllo", 1, 10, 13},
+		{token.SEMICOLON, ";", 1, 17, 21},
+		{token.IDENT, "π_", 2, 1, 23},
+		{token.INT, "2", 2, 3, 26},
+		{token.ILLEGAL, "@", 2, 5, 28},
+		{token.IDENT, "ü", 2, 7, 30},
+		{token.ILLEGAL, "€", 2, 8, 32},
+		{token.EOF, "", 2, 9, 35},
+	}
+	l := New(input)
+	for i, e := range expect {
+		tok := l.NextToken()
+		if tok.Type != e.expectedType || tok.Literal != e.expectedLiteral {
+			t.Fatalf("token %d: expected %s %q, found %s %q", i, e.expectedType, e.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != e.line || tok.Pos.Column != e.column || tok.Pos.Offset != e.offset {
+			t.Errorf("token %d (%s): expected %d:%d (offset %d), found %d:%d (offset %d)",
+				i, tok.Literal, e.line, e.column, e.offset, tok.Pos.Line, tok.Pos.Column, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	for _, input := range []string{"0", "42", "1_000_000", "0xFF", "0Xdead_BEEF", "0o17", "0b1010"} {
+		l := New(input)
+		tok := l.NextToken()
+		if tok.Type != token.INT || tok.Literal != input {
+			t.Errorf("expected INT %q, found %s %q", input, tok.Type, tok.Literal)
+		}
+		if len(l.Diagnostics()) != 0 {
+			t.Errorf("%q: unexpected errors: %v", input, l.Diagnostics())
+		}
+	}
+}
+
+func TestFloatLiterals(t *testing.T) {
+	for _, input := range []string{"3.14", "0.5", "1e9", "1e-9", "2.5E+3", "1_000.5", "0e0"} {
+		l := New(input)
+		tok := l.NextToken()
+		if tok.Type != token.FLOAT || tok.Literal != input {
+			t.Errorf("expected FLOAT %q, found %s %q", input, tok.Type, tok.Literal)
+		}
+		if len(l.Diagnostics()) != 0 {
+			t.Errorf("%q: unexpected errors: %v", input, l.Diagnostics())
+		}
+	}
+
+	// "."之后不是数字时不属于数字字面量
+	l := New("1.a")
+	for _, expected := range []token.TokenType{token.INT, token.ILLEGAL, token.IDENT, token.EOF} {
+		if tok := l.NextToken(); tok.Type != expected {
+			t.Errorf("expected %s, found %s %q", expected, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNumberErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		start  string
+		end    string
+		errMsg string
+	}{
+		{"0x", "1:1", "1:3", "hexadecimal literal has no digits"},
+		{"0b;", "1:1", "1:3", "binary literal has no digits"},
+		{"1__0", "1:3", "1:4", "`_` must separate successive digits"},
+		{"10_", "1:3", "1:4", "`_` must separate successive digits"},
+		{"0x_1", "1:3", "1:4", "`_` must separate successive digits"},
+		{"0x1G", "1:4", "1:5", "invalid digit 'G' in hexadecimal literal"},
+		{"0o18", "1:4", "1:5", "invalid digit '8' in octal literal"},
+		{"0b102", "1:5", "1:6", "invalid digit '2' in binary literal"},
+		{"12ab", "1:3", "1:4", "invalid digit 'a' in decimal literal"},
+		{"017", "1:1", "1:4", "leading zeros are not allowed in decimal literals"},
+		{"1e", "1:2", "1:3", "exponent has no digits"},
+		{"1.5e+", "1:4", "1:5", "exponent has no digits"},
+		{"1_.5", "1:2", "1:3", "`_` must separate successive digits"},
+		{"1.5x", "1:4", "1:5", "invalid digit 'x' in float literal"},
+		{"1e5e5", "1:4", "1:5", "invalid digit 'e' in float literal"},
+	}
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("case %d: expected ILLEGAL, found %s", i, tok.Type)
+		}
+		errors := l.Diagnostics()
+		if len(errors) != 1 {
+			t.Fatalf("case %d: expected 1 error, found %d: %v", i, len(errors), errors)
+		}
+		d := errors[0]
+		if d.Message != tt.errMsg {
+			t.Errorf("case %d: expected message %q, found %q", i, tt.errMsg, d.Message)
+		}
+		if d.Span.Start.String() != tt.start || d.Span.End.String() != tt.end {
+			t.Errorf("case %d: expected span %s-%s, found %s-%s", i, tt.start, tt.end, d.Span.Start, d.Span.End)
+		}
+	}
+}
@@ -1,12 +1,104 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"monkey_cc/compiler"
+	"monkey_cc/diagnostic"
+	"monkey_cc/lexer"
+	"monkey_cc/object"
+	"monkey_cc/parser"
 	"monkey_cc/repl"
 	"os"
 )
 
+// 退出码
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+const usage = `usage: monkey [-engine vm|eval] [command file]
+
+With no command, start the interactive REPL.
+
+commands:
+  run  <file>   compile the file and execute it on the VM
+  eval <file>   execute the file with the tree-walking evaluator
+
+flags:
+`
+
+// 命令与执行引擎的对应关系
+var commandEngines = map[string]string{
+	"run":  repl.EngineVM,
+	"eval": repl.EngineEval,
+}
+
 func main() {
-	fmt.Println("Start monkey lan interpreter.")
-	repl.Start(os.Stdin, os.Stdout)
+	engine := flag.String("engine", repl.EngineVM, "execution engine used by the REPL")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Start monkey lan interpreter.")
+		if err := repl.Start(os.Stdin, os.Stdout, *engine); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		return
+	}
+	os.Exit(run(flag.Args(), os.Stdout, os.Stderr))
+}
+
+// run 执行命令行指定的文件，返回进程的退出码
+// 程序的输出写入stdout，错误信息输出到stderr
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	command, path := args[0], args[1]
+	engineName, ok := commandEngines[command]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command: %s\n", command)
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitError
+	}
+	p := parser.New(lexer.NewWithFilename(path, string(source)))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		// 诊断信息中已包含文件名与位置
+		for _, d := range p.Diagnostics() {
+			diagnostic.Render(stderr, string(source), d)
+		}
+		return exitError
+	}
+
+	object.Stdout = stdout
+	engine, _ := repl.NewEngine(engineName)
+	if _, err := engine.Run(program); err != nil {
+		var runtimeErr *object.Error
+		var compileErr *compiler.Error
+		if errors.As(err, &runtimeErr) || errors.As(err, &compileErr) && compileErr.Position.IsValid() {
+			// 错误的位置中已包含文件名
+			fmt.Fprintln(stderr, repl.FormatError(err))
+		} else {
+			fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		}
+		return exitError
+	}
+	return exitOK
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		source     string
+		exitCode   int
+		output     string
+		errMessage string
+	}{
+		{`let a = 1; puts(a + 1);`, exitOK, "2\n", ""},
+		{`puts("a", [1, "b"]); puts();`, exitOK, "a\n[1, \"b\"]\n", ""},
+		{`puts(1); len(1); puts(2);`, exitError, "1\n", "script.mk:1:13: argument type INTEGER to `len` is not supported"},
+		{`let a = ;`, exitError, "", "no prefix parse function for ; found"},
+		{`len(1);`, exitError, "", "script.mk:1:4: argument type INTEGER to `len` is not supported"},
+	}
+
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, "script.mk")
+		if err := os.WriteFile(path, []byte(tt.source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, command := range []string{"run", "eval"} {
+			var stdout, stderr bytes.Buffer
+			code := run([]string{command, path}, &stdout, &stderr)
+			if code != tt.exitCode {
+				t.Errorf("test %d (%s): expected exit code %d, found %d", i, command, tt.exitCode, code)
+			}
+			if stdout.String() != tt.output {
+				t.Errorf("test %d (%s): expected stdout %q, found %q", i, command, tt.output, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.errMessage) {
+				t.Errorf("test %d (%s): expected stderr to contain %q, found %q", i, command, tt.errMessage, stderr.String())
+			}
+		}
+	}
+}
+
+// 未定义的变量在虚拟机上是编译错误，在求值器中是运行时错误
+func TestRunUndefinedIdentifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.mk")
+	if err := os.WriteFile(path, []byte("b + 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"run":  "compile error: " + path + ":1:1: identifier not found: b\n",
+		"eval": "runtime error: " + path + ":1:1: identifier not found: b\n",
+	}
+	for _, command := range []string{"run", "eval"} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{command, path}, &stdout, &stderr)
+		if code != exitError {
+			t.Errorf("%s: expected exit code %d, found %d", command, exitError, code)
+		}
+		if stderr.String() != expect[command] {
+			t.Errorf("%s: expected stderr %q, found %q", command, expect[command], stderr.String())
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := [][]string{
+		{"run"},
+		{"compile", "script.mk"},
+		{"run", "a.mk", "b.mk"},
+	}
+	for _, args := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr); code != exitUsage {
+			t.Errorf("args %v: expected exit code %d, found %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestRunMissingFile(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	path := filepath.Join(t.TempDir(), "missing.mk")
+	if code := run([]string{"run", path}, &stdout, &stderr); code != exitError {
+		t.Errorf("expected exit code %d, found %d", exitError, code)
+	}
+}
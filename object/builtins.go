@@ -0,0 +1,113 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Stdout puts的输出目标，默认为标准输出
+// 命令行与REPL将其设置为各自的输出
+var Stdout io.Writer = os.Stdout
+
+// Builtins 内置函数表，求值器与虚拟机共用
+// 编译器按照在表中的位置生成OpGetBuiltin的操作数，因此只能在末尾追加
+var Builtins = []struct {
+	Name    string
+	BuiltIn *BuiltIn
+}{
+	{
+		"len",
+		&BuiltIn{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expect: %d, found: %d.", 1, len(args))
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			default:
+				return newError("argument type %s to `len` is not supported", arg.Type())
+			}
+		}},
+	},
+	{
+		"puts",
+		&BuiltIn{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				// 字符串直接输出内容，不带引号
+				if str, ok := arg.(*String); ok {
+					fmt.Fprintln(Stdout, str.Value)
+				} else {
+					fmt.Fprintln(Stdout, arg.Inspect())
+				}
+			}
+			return NULL
+		}},
+	},
+	{
+		"int",
+		&BuiltIn{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expect: %d, found: %d.", 1, len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				// 向零取整，超出int64范围时报错
+				if math.IsNaN(arg.Value) || arg.Value >= math.MaxInt64 || arg.Value < math.MinInt64 {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				value, err := strconv.ParseInt(arg.Value, 10, 64)
+				if err != nil {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return &Integer{Value: value}
+			default:
+				return newError("argument type %s to `int` is not supported", arg.Type())
+			}
+		}},
+	},
+	{
+		"float",
+		&BuiltIn{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expect: %d, found: %d.", 1, len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return newError("cannot convert %s to FLOAT", arg.Inspect())
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument type %s to `float` is not supported", arg.Type())
+			}
+		}},
+	},
+}
+
+// GetBuiltinByName 按名称查找内置函数，不存在时返回nil
+func GetBuiltinByName(name string) *BuiltIn {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.BuiltIn
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -2,7 +2,7 @@ package object
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s}
+	return &Environment{store: s, consts: make(map[string]bool)}
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -12,8 +12,9 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 }
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	consts map[string]bool // 用const定义的变量
+	outer  *Environment
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -24,7 +25,41 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Assign 修改变量在定义它的作用域中的值，变量未定义时返回false
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// SetConst 在当前作用域中定义常量
+func (e *Environment) SetConst(name string, val Object) Object {
+	e.consts[name] = true
+	return e.Set(name, val)
+}
+
+// IsConst 变量在定义它的作用域中是否为常量
+func (e *Environment) IsConst(name string) bool {
+	if _, ok := e.store[name]; ok {
+		return e.consts[name]
+	}
+	if e.outer != nil {
+		return e.outer.IsConst(name)
+	}
+	return false
+}
+
+// IsLocalConst 当前作用域中是否已定义同名常量，常量不能在同一作用域中被重新定义
+func (e *Environment) IsLocalConst(name string) bool {
+	return e.consts[name]
+}
@@ -5,26 +5,39 @@ import (
 	"fmt"
 	"hash/fnv"
 	"monkey_cc/ast"
+	"monkey_cc/code"
+	"monkey_cc/token"
+	"strconv"
 	"strings"
 )
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	STRING_OBJ       = "STRING"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
+	EXCEPTION_OBJ    = "EXCEPTION"
 	FUNCTION_OBJ     = "FUNCTION"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 var (
 	TRUE  = &Boolean{Value: true}
 	FALSE = &Boolean{Value: false}
 	NULL  = &Null{}
+	// 求值器中break与continue的信号，沿语句块向外传递直至所在的循环
+	BREAK    = &Break{}
+	CONTINUE = &Continue{}
 )
 
 type ObjectType string
@@ -88,6 +101,23 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// Inspect 总是带有小数点或指数，以便与整数区分
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -108,8 +138,32 @@ func (s *String) Type() ObjectType {
 	return STRING_OBJ
 }
 
+// Inspect 返回带引号的字符串，其中的特殊字符会被转义
 func (s *String) Inspect() string {
-	return "\"" + s.Value + "\""
+	var out strings.Builder
+	out.WriteByte('"')
+	for _, r := range s.Value {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		default:
+			if r < ' ' || r == 0x7f {
+				fmt.Fprintf(&out, `\u{%X}`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
 }
 
 type Null struct{}
@@ -132,8 +186,35 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+func (b *Break) Inspect() string { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+func (c *Continue) Inspect() string { return "continue" }
+
+// Error 运行时错误，两种引擎对同一错误给出相同的信息、位置与调用栈
 type Error struct {
 	Message string
+	// Position 出错的表达式在源码中的位置，未知时为零值
+	Position token.Position
+	// Opcode 出错的指令名称，仅由虚拟机记录
+	Opcode string
+	// Frames 错误依次退出的Monkey函数调用，由内向外排列
+	Frames []Frame
+	// Value throw语句抛出的值，引擎产生的错误为nil
+	Value Object
+}
+
+// Frame 调用栈中的一次函数调用
+type Frame struct {
+	Function string         // 被调用的函数名称，匿名函数为空
+	Call     token.Position // 调用表达式的位置
 }
 
 func (e *Error) Type() ObjectType {
@@ -142,7 +223,129 @@ func (e *Error) Type() ObjectType {
 
 func (e *Error) Inspect() string { return "ERROR: " + e.Message }
 
+// Error 使运行时错误可以作为Go的error返回
+func (e *Error) Error() string { return e.Message }
+
+// StackTrace 返回带有位置与调用栈的错误信息，每个正在执行的函数占一行，最内层在前
+//
+//	2:14: type mismatch: INTEGER + BOOLEAN (OpAdd)
+//	    at add (2:14)
+//	    at <main> (3:4)
+//
+// 错误不在函数中发生时省略调用栈，连续重复的调用只输出一次并注明重复的次数
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+
+	if e.Position.IsValid() {
+		out.WriteString(e.Position.String() + ": ")
+	}
+	out.WriteString(e.Message)
+	if e.Opcode != "" {
+		out.WriteString(" (" + e.Opcode + ")")
+	}
+	frames, outer := e.frames()
+	for i := 0; i < len(frames); {
+		// 递归调用产生的连续相同的行只保留一行，其余合并为"... N more"
+		n := 1
+		for i+n < len(frames) && frames[i+n] == frames[i] {
+			n++
+		}
+		out.WriteString("\n    at " + frames[i])
+		if n > 1 {
+			out.WriteString(fmt.Sprintf("\n    ... %d more", n-1))
+		}
+		i += n
+	}
+	if len(frames) > 0 {
+		out.WriteString("\n    at " + frameString("<main>", outer))
+	}
+	return out.String()
+}
+
+// 错误依次退出的函数及其执行到的位置，形如"add (2:14)"，最内层在前
+// 每个函数执行到的位置即为其内层函数被调用的位置，outer为最外层的函数被调用的位置
+func (e *Error) frames() (frames []string, outer token.Position) {
+	outer = e.Position
+	for _, f := range e.Frames {
+		name := f.Function
+		if name == "" {
+			name = "<anonymous>"
+		}
+		frames = append(frames, frameString(name, outer))
+		outer = f.Call
+	}
+	return frames, outer
+}
+
+func frameString(name string, pos token.Position) string {
+	if !pos.IsValid() {
+		return name
+	}
+	return name + " (" + pos.String() + ")"
+}
+
+// Throw 将throw语句抛出的值转化为错误
+// 抛出被捕获的异常时重新抛出原来的错误，保留其位置与调用栈
+func Throw(value Object) *Error {
+	if exception, ok := value.(*Exception); ok {
+		return exception.Err
+	}
+	message := value.Inspect()
+	if str, ok := value.(*String); ok {
+		message = str.Value
+	}
+	return &Error{Message: message, Value: value}
+}
+
+// Exception 被catch捕获的错误，在Monkey代码中作为普通的值使用
+// 通过索引读取错误的属性，如e["message"]
+type Exception struct {
+	Err *Error
+}
+
+func (e *Exception) Type() ObjectType {
+	return EXCEPTION_OBJ
+}
+
+func (e *Exception) Inspect() string { return "error: " + e.Err.Message }
+
+// Field 返回异常的属性，不存在的属性返回NULL
+//
+//	message   错误信息
+//	type      由throw抛出时为"Error"，引擎产生的错误为"RuntimeError"
+//	value     throw抛出的值，引擎产生的错误为null
+//	position  出错的位置，形如"2:14"
+//	trace     错误在被捕获前退出的函数，最内层在前
+func (e *Exception) Field(name string) Object {
+	switch name {
+	case "message":
+		return &String{Value: e.Err.Message}
+	case "type":
+		if e.Err.Value != nil {
+			return &String{Value: "Error"}
+		}
+		return &String{Value: "RuntimeError"}
+	case "value":
+		if e.Err.Value != nil {
+			return e.Err.Value
+		}
+	case "position":
+		if e.Err.Position.IsValid() {
+			return &String{Value: e.Err.Position.String()}
+		}
+	case "trace":
+		frames, _ := e.Err.frames()
+		trace := &Array{Elements: []Object{}}
+		for _, frame := range frames {
+			trace.Elements = append(trace.Elements, &String{Value: frame})
+		}
+		return trace
+	}
+	return NULL
+}
+
 type Function struct {
+	Name       string // let或const绑定的名称，匿名函数为空
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -169,6 +372,79 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction 编译器生成的函数，保存在常量池中
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int // 局部变量个数（包括参数），用于在栈上预留空间
+	NumParameters int
+	Name          string                 // 函数名称，匿名函数为空
+	Positions     map[int]token.Position // 指令的起始地址到源码位置的映射
+	// Handlers 异常表，嵌套的try语句中内层的项在前
+	Handlers []code.Handler
+}
+
+// HandlerAt 返回处理地址ip处错误的异常表项，即覆盖ip的最内层项
+func (cf *CompiledFunction) HandlerAt(ip int) (code.Handler, bool) {
+	for _, h := range cf.Handlers {
+		if h.Start <= ip && ip < h.End {
+			return h, true
+		}
+	}
+	return code.Handler{}, false
+}
+
+// PositionAt 返回地址ip所在的指令对应的源码位置
+// ip可以指向指令的操作数，此时返回该指令的位置
+func (cf *CompiledFunction) PositionAt(ip int) token.Position {
+	start := -1
+	for offset := range cf.Positions {
+		if offset <= ip && offset > start {
+			start = offset
+		}
+	}
+	if start < 0 {
+		return token.Position{}
+	}
+	return cf.Positions[start]
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure 编译后的函数与其捕获的自由变量
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+// 闭包在Monkey代码中即为函数，类型与求值器中的Function一致
+func (c *Closure) Type() ObjectType {
+	return FUNCTION_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell 被闭包捕获的局部变量的共享存储，定义变量的函数与捕获它的闭包读写同一个单元
+// 只在虚拟机的变量槽与闭包的自由变量中出现，不会作为值压栈
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType {
+	return CELL_OBJ
+}
+
+func (c *Cell) Inspect() string {
+	return "cell(" + c.Value.Inspect() + ")"
+}
+
 type BuiltIn struct {
 	Fn BuiltInFn
 }
@@ -226,7 +502,7 @@ func (h *Hash) Inspect() string {
 	}
 
 	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ". "))
+	out.WriteString(strings.Join(pairs, ", "))
 	out.WriteString("}")
 
 	return out.String()
@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"monkey_cc/token"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello world"}
@@ -17,3 +20,136 @@ func TestStringHashKey(t *testing.T) {
 		t.Fatalf("strings with different content have same hash keys")
 	}
 }
+
+func TestEnvironmentConst(t *testing.T) {
+	outer := NewEnvironment()
+	outer.SetConst("c", &Integer{Value: 1})
+	outer.Set("v", &Integer{Value: 2})
+	inner := NewEnclosedEnvironment(outer)
+
+	if !inner.IsConst("c") || inner.IsConst("v") {
+		t.Fatalf("constness is not looked up in the defining scope")
+	}
+	if inner.IsLocalConst("c") || !outer.IsLocalConst("c") {
+		t.Fatalf("IsLocalConst should only look at the current scope")
+	}
+	inner.Set("c", &Integer{Value: 3})
+	if inner.IsConst("c") {
+		t.Fatalf("a variable shadowing a constant is not constant")
+	}
+}
+
+func TestStringInspect(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect string
+	}{
+		{"monkey", `"monkey"`},
+		{"a\nb\tc\r", `"a\nb\tc\r"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`back\slash`, `"back\\slash"`},
+		{"\x00\x1b", `"\u{0}\u{1B}"`},
+		{"αβ", `"αβ"`},
+	}
+	for _, tt := range tests {
+		s := &String{Value: tt.value}
+		if s.Inspect() != tt.expect {
+			t.Errorf("expected %s, found %s", tt.expect, s.Inspect())
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value  float64
+		expect string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-3, "-3.0"},
+		{1e21, "1e+21"},
+		{1e-9, "1e-09"},
+	}
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expect {
+			t.Errorf("expected %s, found %s", tt.expect, f.Inspect())
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	tests := []struct {
+		err    *Error
+		expect string
+	}{
+		{&Error{Message: "boom"}, "boom"},
+		{
+			&Error{Message: "boom", Position: token.Position{Line: 1, Column: 3}, Opcode: "OpAdd"},
+			"1:3: boom (OpAdd)",
+		},
+		{
+			&Error{
+				Message:  "boom",
+				Position: token.Position{Line: 2, Column: 5},
+				Frames: []Frame{
+					{Function: "", Call: token.Position{Line: 3, Column: 9}},
+					{Function: "f", Call: token.Position{Line: 4, Column: 2}},
+				},
+			},
+			"2:5: boom\n    at <anonymous> (2:5)\n    at f (3:9)\n    at <main> (4:2)",
+		},
+		{
+			&Error{
+				Message:  "boom",
+				Position: token.Position{Line: 2, Column: 5},
+				Frames: []Frame{
+					{Function: "f", Call: token.Position{Line: 3, Column: 4}},
+					{Function: "f", Call: token.Position{Line: 3, Column: 4}},
+					{Function: "f", Call: token.Position{Line: 3, Column: 4}},
+					{Function: "f", Call: token.Position{Line: 5, Column: 2}},
+				},
+			},
+			"2:5: boom\n    at f (2:5)\n    at f (3:4)\n    ... 2 more\n    at <main> (5:2)",
+		},
+	}
+	for _, tt := range tests {
+		if tt.err.StackTrace() != tt.expect {
+			t.Errorf("expected %q, found %q", tt.expect, tt.err.StackTrace())
+		}
+	}
+}
+
+func TestExceptionField(t *testing.T) {
+	thrown := &Exception{Err: Throw(&Integer{Value: 42})}
+	thrown.Err.Position = token.Position{Line: 2, Column: 5}
+	thrown.Err.Frames = []Frame{{Function: "f", Call: token.Position{Line: 3, Column: 1}}}
+	runtime := &Exception{Err: &Error{Message: "boom"}}
+
+	tests := []struct {
+		exception *Exception
+		field     string
+		expect    string
+	}{
+		{thrown, "message", `"42"`},
+		{thrown, "type", `"Error"`},
+		{thrown, "value", "42"},
+		{thrown, "position", `"2:5"`},
+		{thrown, "trace", `["f (2:5)"]`},
+		{runtime, "type", `"RuntimeError"`},
+		{runtime, "value", "null"},
+		{runtime, "position", "null"},
+		{runtime, "trace", "[]"},
+		{runtime, "unknown", "null"},
+	}
+	for _, tt := range tests {
+		if result := tt.exception.Field(tt.field).Inspect(); result != tt.expect {
+			t.Errorf("%s: expected %s, found %s", tt.field, tt.expect, result)
+		}
+	}
+
+	// 重新抛出被捕获的异常时保留原来的错误
+	if Throw(thrown) != thrown.Err {
+		t.Errorf("rethrowing an exception should keep its error")
+	}
+}
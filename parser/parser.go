@@ -3,9 +3,11 @@ package parser
 import (
 	"fmt"
 	"monkey_cc/ast"
+	"monkey_cc/diagnostic"
 	"monkey_cc/lexer"
 	"monkey_cc/token"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -17,12 +19,15 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
 	OR
 	AND
 	BIT_OR
+	BIT_XOR
 	BIT_AND
 	EQUALS
 	LESSGREATER
+	SHIFT
 	SUM
 	PRODUCT
 	PREFIX
@@ -31,26 +36,47 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.AND:      AND,
-	token.OR:       OR,
-	token.BIT_AND:  BIT_AND,
-	token.BIT_OR:   BIT_OR,
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.AND:             AND,
+	token.OR:              OR,
+	token.BIT_AND:         BIT_AND,
+	token.BIT_OR:          BIT_OR,
+	token.BIT_XOR:         BIT_XOR,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.SHL:             SHIFT,
+	token.SHR:             SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []diagnostic.Diagnostic
 	peek   *token.Token
+	// 已收集的词法错误数量
+	lexErrors int
+	// 最近一次消耗的token
+	last *token.Token
+	// 已消耗但尚未闭合的"{"的数量
+	depth int
+	// 当前函数体中嵌套的循环层数，用于检查break与continue的位置
+	loopDepth int
+	// 恐慌模式：记录错误后到同步点之前，不再记录新的错误，避免连锁的误报
+	panicking bool
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -61,7 +87,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:              l,
-		errors:         []string{},
+		errors:         []diagnostic.Diagnostic{},
 		peek:           nil,
 		prefixParseFns: map[token.TokenType]prefixParseFn{},
 		infixParseFns:  map[token.TokenType]infixParseFn{},
@@ -71,11 +97,13 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.registerPrefix(token.IDENT, p.ParseIdent)
 	p.registerPrefix(token.INT, p.ParseInt)
+	p.registerPrefix(token.FLOAT, p.ParseFloat)
 	p.registerPrefix(token.TRUE, p.ParseBoolean)
 	p.registerPrefix(token.FALSE, p.ParseBoolean)
 	p.registerPrefix(token.STRING, p.ParseString)
 	p.registerPrefix(token.MINUS, p.ParsePrefixExpression)
 	p.registerPrefix(token.BANG, p.ParsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.ParsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.ParseGroupedExp)
 	p.registerPrefix(token.IF, p.ParseIfExp)
 	p.registerPrefix(token.FUNCTION, p.ParseFnLiteral)
@@ -86,14 +114,25 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.ParseInfixExpression)
 	p.registerInfix(token.SLASH, p.ParseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.ParseInfixExpression)
+	p.registerInfix(token.PERCENT, p.ParseInfixExpression)
 	p.registerInfix(token.EQ, p.ParseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.ParseInfixExpression)
 	p.registerInfix(token.LT, p.ParseInfixExpression)
 	p.registerInfix(token.GT, p.ParseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.ParseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.ParseInfixExpression)
 	p.registerInfix(token.AND, p.ParseInfixExpression)
 	p.registerInfix(token.OR, p.ParseInfixExpression)
 	p.registerInfix(token.BIT_AND, p.ParseInfixExpression)
 	p.registerInfix(token.BIT_OR, p.ParseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.ParseInfixExpression)
+	p.registerInfix(token.SHL, p.ParseInfixExpression)
+	p.registerInfix(token.SHR, p.ParseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.ParseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.ParseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.ParseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.ParseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.ParseAssignExpression)
 	p.registerInfix(token.LPAREN, p.ParseCallExp)
 	p.registerInfix(token.LBRACKET, p.ParseIndexExpression)
 
@@ -106,7 +145,27 @@ func (p *Parser) peekToken() *token.Token {
 
 func (p *Parser) nextToken() *token.Token {
 	cur := p.peekToken()
+	if cur != nil {
+		switch cur.Type {
+		case token.LBRACE:
+			p.depth++
+		case token.RBRACE:
+			if p.depth > 0 {
+				p.depth--
+			}
+		}
+	}
+	p.last = cur
 	p.peek = p.l.NextToken()
+	// 注释不参与语法分析
+	for p.peek.Type == token.COMMENT {
+		p.peek = p.l.NextToken()
+	}
+	// 词法错误与语句无关，不受恐慌模式影响
+	if lexErrors := p.l.Diagnostics(); len(lexErrors) > p.lexErrors {
+		p.errors = append(p.errors, lexErrors[p.lexErrors:]...)
+		p.lexErrors = len(lexErrors)
+	}
 	return cur
 }
 
@@ -119,14 +178,31 @@ func (p *Parser) peekPrecedence() int {
 	return LOWEST
 }
 
-// 消耗所有到分号的token，包括这个分号
-func (p *Parser) skipToSemicolonOrRBrace() {
-	peek := p.peekToken()
-	for peek.Type != token.SEMICOLON && peek.Type != token.RBRACE {
+// 错误恢复：跳过token直到语句边界，depth为出错语句开始时的"{"层数
+// 在同一层中遇到";"时消耗它并停止，遇到let、return、"}"或EOF时停止但不消耗
+// 更深层的花括号块会被整体跳过
+func (p *Parser) synchronize(depth int) {
+	// 出错的语句可能已经消耗了结尾的";"
+	if p.last != nil && p.last.Type == token.SEMICOLON && p.depth <= depth {
+		return
+	}
+	for {
+		tok := p.peekToken()
+		if tok.Type == token.EOF {
+			return
+		}
+		if p.depth <= depth {
+			switch tok.Type {
+			case token.SEMICOLON:
+				p.nextToken()
+				return
+			case token.LET, token.CONST, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE,
+				token.TRY, token.THROW, token.RBRACE:
+				return
+			}
+		}
 		p.nextToken()
-		peek = p.peekToken()
 	}
-	p.nextToken()
 }
 
 // 会消耗形如"(...)"的词法单元，返回Ident列表
@@ -139,6 +215,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return identifiers
 	}
 
+	if !p.expectPeekType(token.IDENT) {
+		return nil
+	}
 	ident := &ast.Identifier{
 		Token: *p.peekToken(),
 		Value: p.peekToken().Literal,
@@ -148,6 +227,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 	for p.peekToken().Type == token.COMMA {
 		p.nextToken()
+		if !p.expectPeekType(token.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{
 			Token: *p.peekToken(),
 			Value: p.peekToken().Literal,
@@ -191,7 +273,7 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	var args []ast.Expression
 
 	p.nextToken()
-	if p.peekToken().Type == token.RPAREN {
+	if p.peekToken().Type == end {
 		p.nextToken()
 		return args
 	}
@@ -209,22 +291,60 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return args
 }
 
+// 期待某种token而未找到时给出的修改建议
+var expectTokenHints = map[token.TokenType]string{
+	token.SEMICOLON: "did you forget a semicolon?",
+	token.RPAREN:    "did you forget a closing `)`?",
+	token.RBRACKET:  "did you forget a closing `]`?",
+	token.RBRACE:    "did you forget a closing `}`?",
+	token.COLON:     "hash entries are written as `key: value`",
+	token.ASSIGN:    "a let statement is written as `let <name> = <value>`",
+	token.IDENT:     "expected a name here",
+	token.IN:        "a for loop is written as `for (<name> in <array>) { ... }`",
+}
+
+// 记录一个错误，出错区间为tok所覆盖的源码
+// 恐慌模式下不再记录错误，直到下一个语句边界
+func (p *Parser) addError(code diagnostic.Code, tok *token.Token, hint string, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.errors = append(p.errors, diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Span:     diagnostic.TokenSpan(tok),
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
 // 当期待token与peekToken不一致时，产生该error
 func (p *Parser) expectTokenError(expect token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, found %s", expect, p.peekToken().Type)
-	p.errors = append(p.errors, msg)
+	peek := p.peekToken()
+	p.addError(diagnostic.UnexpectedToken, peek, expectTokenHints[expect],
+		"expected next token to be %s, found %s", expect, peek.Type)
 }
 
 // 当当前token不是int时，产生该error
-func (p *Parser) parseIntError(s string) {
-	msg := fmt.Sprintf("could not parse %s as integer", s)
-	p.errors = append(p.errors, msg)
+func (p *Parser) parseIntError(tok *token.Token) {
+	p.addError(diagnostic.InvalidInteger, tok, "", "could not parse %s as integer", tok.Literal)
+}
+
+// 浮点数超出float64的范围时，产生该error
+func (p *Parser) parseFloatError(tok *token.Token) {
+	p.addError(diagnostic.InvalidNumber, tok, "", "could not parse %s as float", tok.Literal)
 }
 
 // 当前token不存在prefix方法时，产生该error
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError(tok *token.Token) {
+	// ILLEGAL的错误已由词法分析器报告，这里只进入恐慌模式
+	if tok.Type == token.ILLEGAL {
+		p.panicking = true
+		return
+	}
+	p.addError(diagnostic.MissingExpression, tok, "expected an expression here",
+		"no prefix parse function for %s found", tok.Type)
 }
 
 // 判断peekToken的TokenType是否为expect，返回bool值
@@ -239,6 +359,21 @@ func (p *Parser) expectPeekType(expect token.TokenType) bool {
 	}
 }
 
+// 消耗语句结尾的";"，返回bool值
+// 仅当语句位于块或程序的末尾，即peekToken为"}"或EOF时，";"可以省略
+// 其余情况下缺少";"时，自动添加错误
+func (p *Parser) expectStatementEnd() bool {
+	switch p.peekToken().Type {
+	case token.SEMICOLON:
+		p.nextToken()
+		return true
+	case token.RBRACE, token.EOF:
+		return true
+	}
+	p.expectTokenError(token.SEMICOLON)
+	return false
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
@@ -247,28 +382,66 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func (p *Parser) Errors() []string {
+// Diagnostics 返回解析过程中产生的全部诊断信息，按出现的先后排列
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
 	return p.errors
 }
 
+// Errors 返回诊断信息的单行文本形式，形如"3:14: message"
+func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, d := range p.errors {
+		msgs[i] = d.String()
+	}
+	return msgs
+}
+
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{
 		Statements: []ast.Statement{},
 	}
 	for p.peekToken().Type != token.EOF {
-		stmt := p.ParseStmt()
-		program.Statements = append(program.Statements, stmt)
+		if stmt := p.ParseStmt(); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
+		}
 	}
 	return program
 }
 
+// ParseStmt 解析一条语句
+// 语句中出现错误时返回nil，并跳过剩余部分，从下一条语句处继续解析
 func (p *Parser) ParseStmt() ast.Statement {
+	start, depth := p.peekToken(), p.depth
+	stmt := p.parseStmt()
+	if !p.panicking {
+		return stmt
+	}
+	p.synchronize(depth)
+	// 顶层多余的"}"不会被任何语句消耗，跳过它以保证解析能够前进
+	if p.peekToken() == start {
+		p.nextToken()
+	}
+	p.panicking = false
+	return nil
+}
+
+func (p *Parser) parseStmt() ast.Statement {
 	// 路由过程不消耗token
 	switch p.peekToken().Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.ParseLetStmt()
 	case token.RETURN:
 		return p.ParseReturnStmt()
+	case token.WHILE:
+		return p.ParseWhileStmt()
+	case token.FOR:
+		return p.ParseForStmt()
+	case token.BREAK, token.CONTINUE:
+		return p.ParseLoopControlStmt()
+	case token.TRY:
+		return p.ParseTryStmt()
+	case token.THROW:
+		return p.ParseThrowStmt()
 	default:
 		return p.ParseExpStmt()
 	}
@@ -278,7 +451,6 @@ func (p *Parser) ParseLetStmt() *ast.LetStatement {
 	ls := &ast.LetStatement{Token: *p.nextToken()}
 
 	if !p.expectPeekType(token.IDENT) {
-		p.skipToSemicolonOrRBrace()
 		return nil
 	}
 
@@ -289,32 +461,163 @@ func (p *Parser) ParseLetStmt() *ast.LetStatement {
 	}
 
 	if !p.expectPeekType(token.ASSIGN) {
-		p.skipToSemicolonOrRBrace()
 		return nil
 	}
 	p.nextToken()
-	// 省略表达式求值部分
-	//p.skipToSemicolonOrRBrace()
 	ls.Value = p.ParseExp(LOWEST)
-	if !p.expectPeekType(token.SEMICOLON) {
+	if fl, ok := ls.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = ls.Name.Value
+	}
+	if !p.expectStatementEnd() {
 		return nil
 	}
-	p.nextToken()
 	return ls
 }
 
 func (p *Parser) ParseReturnStmt() *ast.ReturnStatement {
 	rs := &ast.ReturnStatement{Token: *p.nextToken()}
-	// 省略表达式求值部分
-	//p.skipToSemicolonOrRBrace()
 	rs.ReturnValue = p.ParseExp(LOWEST)
-	if !p.expectPeekType(token.SEMICOLON) {
+	if !p.expectStatementEnd() {
 		return nil
 	}
-	p.nextToken()
 	return rs
 }
 
+func (p *Parser) ParseWhileStmt() *ast.WhileStatement {
+	ws := &ast.WhileStatement{Token: *p.nextToken()}
+	if !p.expectPeekType(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	ws.Condition = p.ParseExp(LOWEST)
+	if !p.expectPeekType(token.RPAREN) {
+		return nil
+	}
+	p.nextToken()
+	ws.Body = p.parseLoopBody()
+	if p.peekToken().Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return ws
+}
+
+func (p *Parser) ParseForStmt() *ast.ForStatement {
+	fs := &ast.ForStatement{Token: *p.nextToken()}
+	if !p.expectPeekType(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	if !p.expectPeekType(token.IDENT) {
+		return nil
+	}
+	idt := *p.nextToken()
+	fs.Variable = &ast.Identifier{Token: idt, Value: idt.Literal}
+	if !p.expectPeekType(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	fs.Iterable = p.ParseExp(LOWEST)
+	if !p.expectPeekType(token.RPAREN) {
+		return nil
+	}
+	p.nextToken()
+	fs.Body = p.parseLoopBody()
+	if p.peekToken().Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return fs
+}
+
+// 解析循环体，循环体中允许出现break与continue
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	if !p.expectPeekType(token.LBRACE) {
+		return nil
+	}
+	p.loopDepth++
+	body := p.ParseBlockStmt()
+	p.loopDepth--
+	return body
+}
+
+// 解析break或continue，二者只能出现在循环体中
+func (p *Parser) ParseLoopControlStmt() ast.Statement {
+	tok := p.nextToken()
+	if p.loopDepth == 0 {
+		p.addError(diagnostic.OutsideLoop, tok, "", "%s outside of a loop", tok.Literal)
+		return nil
+	}
+	if p.peekToken().Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: *tok}
+	}
+	return &ast.ContinueStatement{Token: *tok}
+}
+
+func (p *Parser) ParseThrowStmt() *ast.ThrowStatement {
+	ts := &ast.ThrowStatement{Token: *p.nextToken()}
+	ts.Value = p.ParseExp(LOWEST)
+	if p.peekToken().Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return ts
+}
+
+// 解析try语句，catch与finally均可省略，但不能同时省略
+func (p *Parser) ParseTryStmt() *ast.TryStatement {
+	ts := &ast.TryStatement{Token: *p.nextToken()}
+	if !p.expectPeekType(token.LBRACE) {
+		return nil
+	}
+	ts.Block = p.ParseBlockStmt()
+	if ts.Block == nil {
+		return nil
+	}
+	if p.peekToken().Type == token.CATCH {
+		p.nextToken()
+		if !p.expectPeekType(token.LPAREN) {
+			return nil
+		}
+		p.nextToken()
+		if !p.expectPeekType(token.IDENT) {
+			return nil
+		}
+		idt := *p.nextToken()
+		ts.Param = &ast.Identifier{Token: idt, Value: idt.Literal}
+		if !p.expectPeekType(token.RPAREN) {
+			return nil
+		}
+		p.nextToken()
+		if !p.expectPeekType(token.LBRACE) {
+			return nil
+		}
+		ts.Catch = p.ParseBlockStmt()
+		if ts.Catch == nil {
+			return nil
+		}
+	}
+	if p.peekToken().Type == token.FINALLY {
+		p.nextToken()
+		if !p.expectPeekType(token.LBRACE) {
+			return nil
+		}
+		ts.Finally = p.ParseBlockStmt()
+		if ts.Finally == nil {
+			return nil
+		}
+	}
+	if ts.Catch == nil && ts.Finally == nil {
+		p.addError(diagnostic.MissingHandler, &ts.Token, "add a `catch (e) { ... }` or `finally { ... }` block",
+			"try without catch or finally")
+		return nil
+	}
+	if p.peekToken().Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return ts
+}
+
 func (p *Parser) ParseBlockStmt() *ast.BlockStatement {
 	block := &ast.BlockStatement{
 		Token:      *p.peekToken(),
@@ -323,19 +626,20 @@ func (p *Parser) ParseBlockStmt() *ast.BlockStatement {
 	p.nextToken()
 
 	for p.peekToken().Type != token.RBRACE && p.peekToken().Type != token.EOF {
-		stmt := p.ParseStmt()
-		block.Statements = append(block.Statements, stmt)
+		if stmt := p.ParseStmt(); stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
 	}
-	if p.peekToken().Type == token.RBRACE {
-		p.nextToken()
+	if !p.expectPeekType(token.RBRACE) {
+		return nil
 	}
+	p.nextToken()
 	return block
 }
 
 func (p *Parser) ParseExpStmt() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: *p.peekToken()}
 	stmt.Exp = p.ParseExp(LOWEST)
-	// p.skipToSemicolonOrRBrace()
 	// 跳过最后的token，如";"
 	// 若为BlockStmt，可能没有";"
 	if p.peekToken().Type == token.SEMICOLON {
@@ -347,7 +651,7 @@ func (p *Parser) ParseExpStmt() *ast.ExpressionStatement {
 func (p *Parser) ParseExp(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.peekToken().Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.peekToken().Type)
+		p.noPrefixParseFnError(p.peekToken())
 		return nil
 	}
 	leftExp := prefix()
@@ -383,6 +687,21 @@ func (p *Parser) ParseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// 赋值是右结合的，a = b = c 解析为 a = (b = c)
+func (p *Parser) ParseAssignExpression(left ast.Expression) ast.Expression {
+	tok := p.nextToken()
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.addError(diagnostic.InvalidAssignment, tok, "only variables and index expressions can be assigned to",
+			"cannot assign to %s", left)
+		return nil
+	}
+	expression := &ast.AssignExpression{Token: *tok, Target: left, Operator: tok.Literal}
+	expression.Value = p.ParseExp(LOWEST)
+	return expression
+}
+
 func (p *Parser) ParseIdent() ast.Expression {
 	return &ast.Identifier{
 		Token: *p.peekToken(),
@@ -394,7 +713,19 @@ func (p *Parser) ParseInt() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: *p.peekToken()}
 	value, err := strconv.ParseInt(p.peekToken().Literal, 0, 64)
 	if err != nil {
-		p.parseIntError(p.peekToken().Literal)
+		p.parseIntError(p.peekToken())
+		return nil
+	}
+	lit.Value = value
+	p.nextToken()
+	return lit
+}
+
+func (p *Parser) ParseFloat() ast.Expression {
+	lit := &ast.FloatLiteral{Token: *p.peekToken()}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.peekToken().Literal, "_", ""), 64)
+	if err != nil {
+		p.parseFloatError(p.peekToken())
 		return nil
 	}
 	lit.Value = value
@@ -421,10 +752,10 @@ func (p *Parser) ParseString() ast.Expression {
 func (p *Parser) ParseGroupedExp() ast.Expression {
 	p.nextToken()
 	exp := p.ParseExp(LOWEST)
-
-	if p.nextToken().Type != token.RPAREN {
+	if !p.expectPeekType(token.RPAREN) {
 		return nil
 	}
+	p.nextToken()
 	return exp
 }
 
@@ -433,7 +764,7 @@ func (p *Parser) ParseIfExp() ast.Expression {
 		Token: *p.peekToken(),
 	}
 	p.nextToken()
-	if p.peekToken().Type != token.LPAREN {
+	if !p.expectPeekType(token.LPAREN) {
 		return nil
 	}
 	p.nextToken()
@@ -463,7 +794,11 @@ func (p *Parser) ParseFnLiteral() ast.Expression {
 	if !p.expectPeekType(token.LBRACE) {
 		return nil
 	}
+	// 函数体外的循环不能被函数体中的break与continue跳出
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.ParseBlockStmt()
+	p.loopDepth = loopDepth
 	return lit
 }
 
@@ -500,9 +835,9 @@ func (p *Parser) ParseIndexExpression(left ast.Expression) ast.Expression {
 func (p *Parser) ParseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: *p.peekToken()} // "{"
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	p.nextToken()
 
 	for p.peekToken().Type != token.RBRACE {
-		p.nextToken()
 		key := p.ParseExp(LOWEST)
 		if !p.expectPeekType(token.COLON) {
 			return nil
@@ -510,8 +845,11 @@ func (p *Parser) ParseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.ParseExp(LOWEST)
 		hash.Pairs[key] = value
-		if p.peekToken().Type != token.RBRACE && !p.expectPeekType(token.COMMA) {
-			return nil
+		if p.peekToken().Type != token.RBRACE {
+			if !p.expectPeekType(token.COMMA) {
+				return nil
+			}
+			p.nextToken()
 		}
 	}
 
@@ -1,9 +1,11 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"monkey_cc/ast"
+	"monkey_cc/diagnostic"
 	"monkey_cc/lexer"
 	"testing"
 )
@@ -175,6 +177,34 @@ func TestLetStmt(t *testing.T) {
 	}
 }
 
+func TestConstStmt(t *testing.T) {
+	input := `const limit = 10; let x = limit;`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	assertNoError(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program does not contain 2 statements: got %d", len(program.Statements))
+	}
+	st, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("statement 0: is not let statement")
+	}
+	if !st.IsConst() || st.TokenLiteral() != "const" {
+		t.Errorf("statement 0: expected const statement, found %s", st.TokenLiteral())
+	}
+	if !assertIntValue(t, st.Value, 10) {
+		return
+	}
+	if program.Statements[1].(*ast.LetStatement).IsConst() {
+		t.Errorf("statement 1: let statement is const")
+	}
+	if program.String() != "const limit = 10;let x = limit;" {
+		t.Errorf("unexpected program %q", program.String())
+	}
+}
+
 func TestReturnStmt(t *testing.T) {
 	input := `
 	return 5;
@@ -260,6 +290,36 @@ func TestIntExpression(t *testing.T) {
 	assertLiteralExp(t, stmt.Exp, 5)
 }
 
+func TestFloatExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+		{"1_000.000_1", 1000.0001},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		assertNoError(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Exp.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp is not *ast.FloatLiteral: %T", stmt.Exp)
+		}
+		if lit.Value != tt.expect {
+			t.Errorf("value expect %g, found %g", tt.expect, lit.Value)
+		}
+		if lit.String() != tt.input {
+			t.Errorf("literal expect %s, found %s", tt.input, lit.String())
+		}
+	}
+}
+
 func TestBoolean(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -359,6 +419,31 @@ func TestHashLiteral(t *testing.T) {
 	}
 }
 
+func TestEmptyCollectionLiteral(t *testing.T) {
+	input := `[]; {};`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	assertNoError(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Exp.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("stmt.Exp is not *ast.ArrayLiteral")
+	}
+	if len(array.Elements) != 0 {
+		t.Fatalf("expect len(array.Elements) to be %d, found %d", 0, len(array.Elements))
+	}
+	stmt, _ = program.Statements[1].(*ast.ExpressionStatement)
+	hash, ok := stmt.Exp.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("stmt.Exp is not *ast.HashLiteral")
+	}
+	if len(hash.Pairs) != 0 {
+		t.Fatalf("expect len(hash.Pairs) to be %d, found %d", 0, len(hash.Pairs))
+	}
+}
+
 func TestPrefixExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -367,6 +452,7 @@ func TestPrefixExpression(t *testing.T) {
 	}{
 		{"!5;", "!", 5},
 		{"-15;", "-", 15},
+		{"~7;", "~", 7},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -655,6 +741,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"1 + (2 + 3) + 4;", "((1 + (2 + 3)) + 4);"},
 		{"2 / (5 + 5);", "(2 / (5 + 5));"},
 		{"a * b[2]", "(a * (b[2]));"},
+		{"a % b * c", "((a % b) * c);"},
+		{"a + b % c", "(a + (b % c));"},
+		{"a <= b == c >= d", "((a <= b) == (c >= d));"},
+		{"1 << 2 + 3", "(1 << (2 + 3));"},
+		{"a >> 1 < b << 1", "((a >> 1) < (b << 1));"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)));"},
+		{"~a + b", "((~a) + b);"},
+		{"a ^ b == c", "(a ^ (b == c));"},
 	}
 
 	for i, tt := range tests {
@@ -674,25 +768,496 @@ func TestExpectTokenError(t *testing.T) {
 	let = 10;
 	let 838 383;`
 	expect := []string{
-		"expected next token to be =, found INT",
-		"expected next token to be IDENT, found =",
-		"expected next token to be IDENT, found INT",
+		"2:8: expected next token to be =, found INT",
+		"3:6: expected next token to be IDENT, found =",
+		"4:6: expected next token to be IDENT, found INT",
 	}
 	l := lexer.New(input)
 	p := New(l)
 	p.ParseProgram()
 	errors := p.Errors()
 
-	if len(errors) == 3 {
-		return
+	if len(errors) != 3 {
+		t.Fatalf("should detected 3 errors: found %d: %q", len(errors), errors)
 	}
 
-	t.Errorf("should detected 3 errors: found %d", len(errors))
-
 	for i, msg := range errors {
 		if msg != expect[i] {
 			t.Errorf("expected %d error to be: %s\nfound: %s\n", i, expect[i], msg)
 		}
 	}
-	t.FailNow()
+}
+
+func TestNodePosition(t *testing.T) {
+	input := `let add = fn(a, b) {
+	a + b;
+};
+add(1, 2)[0];`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	assertNoError(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	infix := body.Exp.(*ast.InfixExpression)
+	stmt := program.Statements[1].(*ast.ExpressionStatement)
+	index := stmt.Exp.(*ast.IndexExpression)
+	call := index.Left.(*ast.CallExpression)
+
+	tests := []struct {
+		node   ast.Node
+		expect string
+	}{
+		{program, "1:1"},
+		{let, "1:1"},
+		{let.Name, "1:5"},
+		{fn, "1:11"},
+		{fn.Parameters[1], "1:17"},
+		{fn.Body, "1:20"},
+		{body, "2:2"},
+		{infix.Left, "2:2"},
+		{infix, "2:4"},
+		{stmt, "4:1"},
+		{call, "4:4"},
+		{call.Arguments[1], "4:8"},
+		{index, "4:10"},
+	}
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.expect {
+			t.Errorf("case %d (%s): expected position %s, found %s", i, tt.node.String(), tt.expect, tt.node.Pos())
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	input := `let a = 1;
+let b = 2;
+let c = add(a, b;`
+	l := lexer.NewWithFilename("test.mk", input)
+	p := New(l)
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+	expect := "test.mk:3:17: expected next token to be ), found ;"
+	if errors[0] != expect {
+		t.Errorf("expected error %q, found %q", expect, errors[0])
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	input := `let x = [1, 2;
+let y = ;`
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+	diags := p.Diagnostics()
+
+	expect := []struct {
+		code  diagnostic.Code
+		start string
+		end   string
+		hint  string
+	}{
+		{diagnostic.UnexpectedToken, "1:14", "1:15", "did you forget a closing `]`?"},
+		{diagnostic.MissingExpression, "2:9", "2:10", "expected an expression here"},
+	}
+	if len(diags) != len(expect) {
+		t.Fatalf("expected %d diagnostics, found %d: %q", len(expect), len(diags), p.Errors())
+	}
+	for i, tt := range expect {
+		d := diags[i]
+		if d.Severity != diagnostic.Error {
+			t.Errorf("case %d: expected severity error, found %s", i, d.Severity)
+		}
+		if d.Code != tt.code {
+			t.Errorf("case %d: expected code %s, found %s", i, tt.code, d.Code)
+		}
+		if d.Span.Start.String() != tt.start || d.Span.End.String() != tt.end {
+			t.Errorf("case %d: expected span %s-%s, found %s-%s", i, tt.start, tt.end, d.Span.Start, d.Span.End)
+		}
+		if d.Hint != tt.hint {
+			t.Errorf("case %d: expected hint %q, found %q", i, tt.hint, d.Hint)
+		}
+	}
+}
+
+func TestRenderDiagnostics(t *testing.T) {
+	source := "let a = 1;\n\tlet b = \"two\" 2;\n"
+	l := lexer.NewWithFilename("test.mk", source)
+	p := New(l)
+	p.ParseProgram()
+	diags := p.Diagnostics()
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, found %d: %q", len(diags), p.Errors())
+	}
+	expect := "test.mk:2:16: error[E0001]: expected next token to be ;, found INT\n" +
+		"  |\n" +
+		"2 | \tlet b = \"two\" 2;\n" +
+		"  | \t              ^\n" +
+		"  = hint: did you forget a semicolon?\n"
+
+	var out bytes.Buffer
+	diagnostic.Render(&out, source, diags[0])
+	if out.String() != expect {
+		t.Errorf("expected:\n%s\nfound:\n%s", expect, out.String())
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input  string
+		errors []string
+		// 出错的语句被丢弃，其余语句正常解析
+		program string
+	}{
+		{
+			"let x = ;\nlet y 5;\nlet z = 1 + ;\nx + y;",
+			[]string{
+				"1:9: no prefix parse function for ; found",
+				"2:7: expected next token to be =, found INT",
+				"3:13: no prefix parse function for ; found",
+			},
+			"(x + y);",
+		},
+		{
+			// 块中的错误在块内恢复，不影响外层语句
+			"let f = fn(a, b) {\n  let = a;\n  a +\n};\nlet g = f(1, 2;\nlet h = [1, 2, 3];",
+			[]string{
+				"2:7: expected next token to be IDENT, found =",
+				"4:1: no prefix parse function for } found",
+				"5:15: expected next token to be ), found ;",
+			},
+			"let f = fn(a, b){};let h = [1, 2, 3];",
+		},
+		{
+			// 出错语句中完整的块被整体跳过，多余的"}"单独报告
+			"if (x { 1 } else { 2 };\nlet y = );\n}\nlet z = 3;",
+			[]string{
+				"1:7: expected next token to be ), found {",
+				"2:9: no prefix parse function for ) found",
+				"3:1: no prefix parse function for } found",
+			},
+			"let z = 3;",
+		},
+		{
+			"let h = {1: , 2: 3}; let ok = 1;\nfn(x) { x",
+			[]string{
+				"1:13: no prefix parse function for , found",
+				"2:10: expected next token to be }, found EOF",
+			},
+			"let ok = 1;",
+		},
+		{
+			")",
+			[]string{"1:1: no prefix parse function for ) found"},
+			"",
+		},
+		{
+			"let a = (1\nlet b = 2;",
+			[]string{"2:1: expected next token to be ), found LET"},
+			"let b = 2;",
+		},
+		{
+			// 缺少括号的条件不会引发后续的连锁错误
+			"if x { 1 }; let b = 2;",
+			[]string{"1:4: expected next token to be (, found IDENT"},
+			"let b = 2;",
+		},
+		{
+			"fn(1) { 1 };\nfn(a, 2) { a };\nlet b = 2;",
+			[]string{
+				"1:4: expected next token to be IDENT, found INT",
+				"2:7: expected next token to be IDENT, found INT",
+			},
+			"let b = 2;",
+		},
+	}
+
+	for i, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != len(tt.errors) {
+			t.Errorf("case %d: expected %d errors, found %d: %q", i, len(tt.errors), len(errors), errors)
+			continue
+		}
+		for j, msg := range errors {
+			if msg != tt.errors[j] {
+				t.Errorf("case %d: expected error %d to be %q, found %q", i, j, tt.errors[j], msg)
+			}
+		}
+		if program.String() != tt.program {
+			t.Errorf("case %d: expected program %q, found %q", i, tt.program, program.String())
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"x = 5", "(x = 5);"},
+		{"x += y * 2", "(x += (y * 2));"},
+		{"x -= 1 - 2", "(x -= (1 - 2));"},
+		{"a[i + 1] *= 3", "((a[(i + 1)]) *= 3);"},
+		{"h[\"k\"] /= 2", "((h[k]) /= 2);"},
+		// 赋值是右结合的
+		{"a = b = c || d", "(a = (b = (c || d)));"},
+		{"let x = y = 1;", "let x = (y = 1);"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		assertNoError(t, p)
+		if program.String() != tt.expect {
+			t.Errorf("expected %q, found %q", tt.expect, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"1 = 2;", "1:3: cannot assign to 1"},
+		{"a + b = c;", "1:7: cannot assign to (a + b)"},
+		{"f() += 1;", "1:5: cannot assign to f()"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		diags := p.Diagnostics()
+		if len(diags) != 1 {
+			t.Errorf("%q: expected 1 error, found %d: %q", tt.input, len(diags), p.Errors())
+			continue
+		}
+		if diags[0].Code != diagnostic.InvalidAssignment {
+			t.Errorf("%q: expected code %s, found %s", tt.input, diagnostic.InvalidAssignment, diags[0].Code)
+		}
+		if diags[0].String() != tt.error {
+			t.Errorf("%q: expected error %q, found %q", tt.input, tt.error, diags[0].String())
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < 10) { x; break; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	assertNoError(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected %d statements: got %d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statement[0] is not *ast.WhileStatement")
+	}
+	if !assertInfixExp(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("expected %d body statements: got %d", 2, len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Body.Statements[1] is not *ast.BreakStatement")
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { if (x == 1) { continue } x; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	assertNoError(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected %d statements: got %d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statement[0] is not *ast.ForStatement")
+	}
+	if !assertIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+	expect := "for (x in [1, 2]) {if(x == 1) {continue;};x;}"
+	if stmt.String() != expect {
+		t.Errorf("expected %q, found %q", expect, stmt.String())
+	}
+}
+
+// 以块结尾的语句后可以跟一个多余的";"
+func TestTrailingSemicolon(t *testing.T) {
+	tests := []struct {
+		input   string
+		program string
+	}{
+		{"while (x < 1) { x; }; x;", "while(x < 1) {x;}x;"},
+		{"for (y in a) { y; }; x;", "for (y in a) {y;}x;"},
+		{"try { x; } catch (e) { e; }; x;", "try {x;} catch (e) {e;}x;"},
+		{"try { x; } finally { y; }; x;", "try {x;} finally {y;}x;"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		assertNoError(t, p)
+		if program.String() != tt.program {
+			t.Errorf("%q: expected %q, found %q", tt.input, tt.program, program.String())
+		}
+	}
+}
+
+func TestStatementEnd(t *testing.T) {
+	tests := []struct {
+		input   string
+		program string
+	}{
+		{"let x = 1", "let x = 1;"},
+		{"return x", "return x;"},
+		{"fn() { let x = 1 }", "fn(){let x = 1;};"},
+		{"fn() { return x }; y;", "fn(){return x;};y;"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		assertNoError(t, p)
+		if program.String() != tt.program {
+			t.Errorf("%q: expected %q, found %q", tt.input, tt.program, program.String())
+		}
+	}
+
+	errTests := []struct {
+		input string
+		err   string
+	}{
+		{"let x = 1 let y = 2;", "1:11: expected next token to be ;, found LET"},
+		{`let b = "two" 2;`, "1:15: expected next token to be ;, found INT"},
+		{"return 1 puts(1);", "1:10: expected next token to be ;, found IDENT"},
+		{"fn() { let x = 1 x }", "1:18: expected next token to be ;, found IDENT"},
+	}
+	for _, tt := range errTests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.err {
+			t.Errorf("%q: expected error %q, found %q", tt.input, tt.err, errors)
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input  string
+		errors []string
+	}{
+		{"break;", []string{"1:1: break outside of a loop"}},
+		{"if (true) { continue; }", []string{"1:13: continue outside of a loop"}},
+		// 函数体中的break不能跳出函数外的循环
+		{"while (true) { fn() { break; }; }", []string{"1:23: break outside of a loop"}},
+		{"while (true) { break; continue }", []string{}},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != len(tt.errors) {
+			t.Errorf("%q: expected %d errors, found %d: %q", tt.input, len(tt.errors), len(errors), errors)
+			continue
+		}
+		for i, msg := range errors {
+			if msg != tt.errors[i] {
+				t.Errorf("%q: expected error %q, found %q", tt.input, tt.errors[i], msg)
+			}
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"try { f(); } catch (e) { e; }", "try {f();} catch (e) {e;}"},
+		{"try { f(); } finally { g(); }", "try {f();} finally {g();}"},
+		{"try { f(); } catch (err) { throw err; } finally { g(); }", "try {f();} catch (err) {throw err;} finally {g();}"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		assertNoError(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected %d statements: got %d", 1, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("program.Statement[0] is not *ast.TryStatement")
+		}
+		if stmt.String() != tt.expect {
+			t.Errorf("expected %q, found %q", tt.expect, stmt.String())
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := `throw "oops"; 1`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	assertNoError(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected %d statements: got %d", 2, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statement[0] is not *ast.ThrowStatement")
+	}
+	if !assertLiteralExp(t, stmt.Value, "oops") {
+		return
+	}
+}
+
+func TestTryErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		code  diagnostic.Code
+		error string
+	}{
+		{"try { 1 } 2;", diagnostic.MissingHandler, "1:1: try without catch or finally"},
+		{"try { 1 } catch { 2 }", diagnostic.UnexpectedToken, "1:17: expected next token to be (, found {"},
+		{"try { 1 } catch (1) { 2 }", diagnostic.UnexpectedToken, "1:18: expected next token to be IDENT, found INT"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		diags := p.Diagnostics()
+		if len(diags) != 1 {
+			t.Errorf("%q: expected 1 error, found %d: %q", tt.input, len(diags), p.Errors())
+			continue
+		}
+		if diags[0].Code != tt.code {
+			t.Errorf("%q: expected code %s, found %s", tt.input, tt.code, diags[0].Code)
+		}
+		if diags[0].String() != tt.error {
+			t.Errorf("%q: expected error %q, found %q", tt.input, tt.error, diags[0].String())
+		}
+	}
 }
@@ -0,0 +1,132 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"monkey_cc/ast"
+	"monkey_cc/compiler"
+	"monkey_cc/evaluator"
+	"monkey_cc/object"
+	"monkey_cc/vm"
+)
+
+const (
+	EngineVM   = "vm"
+	EngineEval = "eval"
+)
+
+// EngineNames 所有可选的执行引擎
+var EngineNames = []string{EngineVM, EngineEval}
+
+// Engine 执行引擎，在多次调用Run之间保留全局状态
+type Engine interface {
+	Name() string
+	// Run 执行一段程序，返回最后一个表达式的值
+	// 值可能为nil，表示没有可以输出的结果
+	Run(program *ast.Program) (object.Object, error)
+}
+
+// NewEngine 按名称创建执行引擎
+func NewEngine(name string) (Engine, error) {
+	switch name {
+	case EngineVM:
+		return newVMEngine(), nil
+	case EngineEval:
+		return newEvalEngine(), nil
+	default:
+		return nil, unknownEngineError(name)
+	}
+}
+
+// FormatError 返回引擎错误的输出形式
+// 运行时错误带有位置、操作码与Monkey函数的调用栈，两种引擎的输出格式一致
+func FormatError(err error) string {
+	var runtimeErr *object.Error
+	if errors.As(err, &runtimeErr) {
+		return "runtime error: " + runtimeErr.StackTrace()
+	}
+	return err.Error()
+}
+
+func unknownEngineError(name string) error {
+	return fmt.Errorf("unknown engine %q, expected one of %v", name, EngineNames)
+}
+
+// 基于编译器与虚拟机的引擎
+type vmEngine struct {
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newVMEngine() *vmEngine {
+	symbolTable := compiler.NewSymbolTable()
+	for i, def := range object.Builtins {
+		symbolTable.DefineBuiltin(i, def.Name)
+	}
+	return &vmEngine{
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalSize),
+		symbolTable: symbolTable,
+	}
+}
+
+func (e *vmEngine) Name() string { return EngineVM }
+
+func (e *vmEngine) Run(program *ast.Program) (object.Object, error) {
+	// 在符号表的副本上编译，编译失败时此前的定义与常量都不受影响
+	symbolTable := e.symbolTable.Clone()
+	comp := compiler.NewWithState(symbolTable, e.constants)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, fmt.Errorf("compile error: %w", err)
+	}
+	bytecode := comp.Bytecode()
+	e.constants = bytecode.Constants
+	machine := vm.NewWithGlobalsStore(bytecode, e.globals)
+	err = machine.Run()
+	if err != nil {
+		// 与求值器一致，执行失败前已完成的定义保留，未执行到的定义撤销
+		symbolTable.Rollback(e.symbolTable, func(s compiler.Symbol) bool {
+			return e.globals[s.Index] != nil
+		})
+		e.symbolTable = symbolTable
+		return nil, err
+	}
+	e.symbolTable = symbolTable
+	// let语句、循环与try语句没有值，与求值器保持一致
+	if endsWithoutValue(program) {
+		return nil, nil
+	}
+	return machine.LastPopped(), nil
+}
+
+func endsWithoutValue(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.LetStatement, *ast.WhileStatement, *ast.ForStatement, *ast.TryStatement:
+		return true
+	}
+	return false
+}
+
+// 基于树遍历求值器的引擎
+type evalEngine struct {
+	env *object.Environment
+}
+
+func newEvalEngine() *evalEngine {
+	return &evalEngine{env: object.NewEnvironment()}
+}
+
+func (e *evalEngine) Name() string { return EngineEval }
+
+func (e *evalEngine) Run(program *ast.Program) (object.Object, error) {
+	result := evaluator.Eval(program, e.env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errObj
+	}
+	return result, nil
+}
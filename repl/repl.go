@@ -4,74 +4,83 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"monkey_cc/compiler"
+	"monkey_cc/diagnostic"
 	"monkey_cc/lexer"
+	"monkey_cc/object"
 	"monkey_cc/parser"
-	"monkey_cc/vm"
+	"strings"
 )
 
 const PROMPT = ">> "
 
-// Start function based on Compiler and VM
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// 以":"开头的输入为REPL的元命令
+const META_PREFIX = ":"
+
+// Start 启动REPL，engineName为初始使用的执行引擎
+// 每个引擎在会话中各自保留状态，使用":engine <name>"切换引擎
+// 程序中puts的输出同样写入out
+func Start(in io.Reader, out io.Writer, engineName string) error {
+	engines := map[string]Engine{}
+	for _, name := range EngineNames {
+		engine, _ := NewEngine(name)
+		engines[name] = engine
+	}
+	engine, ok := engines[engineName]
+	if !ok {
+		return unknownEngineError(engineName)
+	}
+	object.Stdout = out
 
+	scanner := bufio.NewScanner(in)
 	for {
 		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
-			return
+			return nil
 		}
 		line := scanner.Text()
+		if strings.HasPrefix(line, META_PREFIX) {
+			engine = runMetaCommand(out, line, engine, engines)
+			continue
+		}
 		l := lexer.New(line)
 		p := parser.New(l)
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			for _, msg := range p.Errors() {
-				io.WriteString(out, "\t"+msg+"\n")
+		if len(p.Diagnostics()) != 0 {
+			for _, d := range p.Diagnostics() {
+				diagnostic.Render(out, line, d)
 			}
+			continue
 		}
-		comp := compiler.New()
-		err := comp.Compile(program)
+		result, err := engine.Run(program)
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			fmt.Fprintf(out, "Woops! %s\n", FormatError(err))
 			continue
 		}
-		machine := vm.New(comp.Bytecode())
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+		if result == nil {
 			continue
 		}
-		io.WriteString(out, machine.LastPopped().Inspect())
+		io.WriteString(out, result.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
 
-// Start function based on evaluater and environment
-// func Start(in io.Reader, out io.Writer) {
-// 	scanner := bufio.NewScanner(in)
-// 	env := object.NewEnvironment()
-
-// 	for {
-// 		fmt.Fprint(out, PROMPT)
-// 		scanned := scanner.Scan()
-// 		if !scanned {
-// 			return
-// 		}
-// 		line := scanner.Text()
-// 		l := lexer.New(line)
-// 		p := parser.New(l)
-// 		program := p.ParseProgram()
-// 		if len(p.Errors()) != 0 {
-// 			for _, msg := range p.Errors() {
-// 				io.WriteString(out, "\t"+msg+"\n")
-// 			}
-// 		}
-// 		evaluated := evaluator.Eval(program, env)
-// 		if evaluated != nil {
-// 			io.WriteString(out, evaluated.Inspect())
-// 			io.WriteString(out, "\n")
-// 		}
-// 	}
-// }
+// 执行元命令，返回执行后使用的引擎
+func runMetaCommand(out io.Writer, line string, current Engine, engines map[string]Engine) Engine {
+	fields := strings.Fields(strings.TrimPrefix(line, META_PREFIX))
+	if len(fields) == 0 || fields[0] != "engine" || len(fields) > 2 {
+		fmt.Fprintf(out, "unknown command %q, usage: :engine [%s]\n", line, strings.Join(EngineNames, "|"))
+		return current
+	}
+	if len(fields) == 1 {
+		fmt.Fprintf(out, "engine: %s\n", current.Name())
+		return current
+	}
+	engine, ok := engines[fields[1]]
+	if !ok {
+		fmt.Fprintf(out, "%s\n", unknownEngineError(fields[1]))
+		return current
+	}
+	fmt.Fprintf(out, "switched to engine: %s\n", engine.Name())
+	return engine
+}
@@ -0,0 +1,155 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSessionKeepsState(t *testing.T) {
+	input := strings.Join([]string{
+		"let x = 5;",
+		"x",
+		"let add = fn(a, b) { a + b };",
+		"add(x, 10)",
+		`let s = "mon";`,
+		`s + "key"`,
+		`puts(s, x)`,
+	}, "\n")
+	expect := []string{"", "5", "", "15", "", `"monkey"`, "mon\n5\nnull"}
+
+	for _, engine := range EngineNames {
+		lines := runSession(t, input, engine)
+		if len(lines) != len(expect) {
+			t.Fatalf("%s: expected %d outputs, found %d: %q", engine, len(expect), len(lines), lines)
+		}
+		for i, line := range lines {
+			if line != expect[i] {
+				t.Errorf("%s: line %d: expected %q, found %q", engine, i, expect[i], line)
+			}
+		}
+	}
+}
+
+func TestSwitchEngine(t *testing.T) {
+	input := strings.Join([]string{
+		":engine",
+		"let x = 1;",
+		":engine eval",
+		"x",
+		"let x = 2;",
+		":engine vm",
+		"x",
+		":engine lisp",
+		":help",
+	}, "\n")
+	expect := []string{
+		"engine: vm",
+		"",
+		"switched to engine: eval",
+		// 每个引擎各自保留状态
+		"Woops! runtime error: 1:1: identifier not found: x",
+		"",
+		"switched to engine: vm",
+		"1",
+		`unknown engine "lisp", expected one of [vm eval]`,
+		`unknown command ":help", usage: :engine [vm|eval]`,
+	}
+	lines := runSession(t, input, EngineVM)
+	if len(lines) != len(expect) {
+		t.Fatalf("expected %d outputs, found %d: %q", len(expect), len(lines), lines)
+	}
+	for i, line := range lines {
+		if line != expect[i] {
+			t.Errorf("line %d: expected %q, found %q", i, expect[i], line)
+		}
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	var out bytes.Buffer
+	err := Start(strings.NewReader(""), &out, "lisp")
+	if err == nil {
+		t.Fatalf("expected error for unknown engine")
+	}
+}
+
+// 运行一次REPL会话，返回每次输入对应的输出
+func runSession(t *testing.T, input, engine string) []string {
+	t.Helper()
+	var out bytes.Buffer
+	err := Start(strings.NewReader(input), &out, engine)
+	if err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	lines := strings.Split(out.String(), PROMPT)
+	// 第一个元素为首个提示符之前的空串，最后一个元素为输入结束后的提示符
+	lines = lines[1 : len(lines)-1]
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
+
+// 执行失败的输入不能留下未写入的全局变量：编译失败时整行输入不生效，
+// 执行失败时保留失败前完成的定义
+func TestSessionAfterFailure(t *testing.T) {
+	input := strings.Join([]string{
+		"let x = 1; y",
+		"x + 1",
+		"let z = 1 / 0",
+		"z + 1",
+		"let a = 2; let a = a * 5; let b = a / 0;",
+		"a",
+		"b",
+	}, "\n")
+	expect := map[string][]string{
+		EngineVM: {
+			"Woops! compile error: 1:12: identifier not found: y",
+			"Woops! compile error: 1:1: identifier not found: x",
+			"Woops! runtime error: 1:11: division by zero (OpDiv)",
+			"Woops! compile error: 1:1: identifier not found: z",
+			"Woops! runtime error: 1:37: division by zero (OpDiv)",
+			"10",
+			"Woops! compile error: 1:1: identifier not found: b",
+		},
+		EngineEval: {
+			"Woops! runtime error: 1:12: identifier not found: y",
+			"2",
+			"Woops! runtime error: 1:11: division by zero",
+			"Woops! runtime error: 1:1: identifier not found: z",
+			"Woops! runtime error: 1:37: division by zero",
+			"10",
+			"Woops! runtime error: 1:1: identifier not found: b",
+		},
+	}
+
+	for _, engine := range EngineNames {
+		lines := runSession(t, input, engine)
+		if len(lines) != len(expect[engine]) {
+			t.Fatalf("%s: expected %d outputs, found %d: %q", engine, len(expect[engine]), len(lines), lines)
+		}
+		for i, line := range lines {
+			if line != expect[engine][i] {
+				t.Errorf("%s: line %d: expected %q, found %q", engine, i, expect[engine][i], line)
+			}
+		}
+	}
+}
+
+func TestParseErrorUnderline(t *testing.T) {
+	expect := strings.Join([]string{
+		"1:17: error[E0001]: expected next token to be ), found ;",
+		"  |",
+		"1 | let c = add(a, b;",
+		"  |                 ^",
+		"  = hint: did you forget a closing `)`?",
+	}, "\n")
+	lines := runSession(t, "let c = add(a, b;", EngineVM)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 output, found %d: %q", len(lines), lines)
+	}
+	if lines[0] != strings.TrimSpace(expect) {
+		t.Errorf("expected:\n%s\nfound:\n%s", expect, lines[0])
+	}
+}
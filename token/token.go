@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type (
 	TokenType string
 )
@@ -7,16 +9,51 @@ type (
 type Token struct {
 	Type    TokenType
 	Literal string
+	// Pos 词法单元第一个字符在源码中的位置
+	Pos Position
+	// End 词法单元之后第一个字符的位置
+	End Position
+}
+
+// Position 源码中的位置，Line与Column从1开始计数，Offset为字节偏移
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// String 返回形如"file:line:column"的位置，没有文件名时省略文件名
+func (p Position) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// IsValid 位置是否已知，零值表示未知的位置
+func (p Position) IsValid() bool {
+	return p.Line > 0
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"true":   TRUE,
-	"false":  FALSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"const":    CONST,
+	"if":       IF,
+	"else":     ELSE,
+	"true":     TRUE,
+	"false":    FALSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 const (
@@ -25,23 +62,39 @@ const (
 
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
+	// COMMENT 仅在词法分析器保留注释时产生
+	COMMENT = "COMMENT"
+
+	ASSIGN = "="
+	// 复合赋值
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
 
-	ASSIGN   = "="
 	PLUS     = "+"
 	MINUS    = "-"
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 	BIT_AND  = "&"
 	BIT_OR   = "|"
+	BIT_XOR  = "^"
+	BIT_NOT  = "~"
+	SHL      = "<<"
+	SHR      = ">>"
 	EQ       = "=="
 	NOT_EQ   = "!="
 
-	LT  = "<"
-	GT  = ">"
-	AND = "&&"
-	OR  = "||"
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
+	AND   = "&&"
+	OR    = "||"
 
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -56,11 +109,21 @@ const (
 
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
 
 func New(t TokenType, l string) *Token {
@@ -0,0 +1,27 @@
+package vm
+
+import (
+	"monkey_cc/code"
+	"monkey_cc/object"
+)
+
+// Frame 调用帧，保存函数调用期间的执行状态
+type Frame struct {
+	cl *object.Closure
+	// ip 指向当前执行的指令，ip == -1 表示尚未开始执行
+	ip int
+	// basePointer 指向栈中第一个局部变量的位置，函数返回时据此恢复栈
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -1,16 +1,23 @@
 package vm
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"monkey_cc/code"
 	"monkey_cc/compiler"
 	"monkey_cc/object"
 )
 
 const (
-	StackSize  = 2048
 	GlobalSize = 65536
+	MaxFrames  = 4096
+	// 单字节操作数最多寻址256个局部变量，栈为每个调用帧预留这么多空间
+	// 使得递归调用在栈溢出之前先达到MaxFrames
+	frameSize = 256
+	StackSize = MaxFrames * frameSize
+	// 栈的初始大小，随使用按需扩大，直到StackSize
+	initialStackSize = 2048
 )
 
 var (
@@ -19,27 +26,68 @@ var (
 	Null  = object.NULL
 )
 
-// 栈式虚拟机，包含三个核心部分：常量、指令、栈
+// 栈式虚拟机，包含三个核心部分：常量、调用帧、栈
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
 
 	stack   []object.Object
 	globals []object.Object
 	sp      int
+
+	frames      []*Frame
+	framesIndex int
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		Handlers:     bytecode.Handlers,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
 	return &VM{
-		instructions: bytecode.Instructions,
-		constants:    bytecode.Constants,
+		constants: bytecode.Constants,
 
-		stack:   make([]object.Object, StackSize),
+		stack:   make([]object.Object, initialStackSize),
 		globals: make([]object.Object, GlobalSize),
 		sp:      -1,
+
+		frames:      frames,
+		framesIndex: 1,
 	}
 }
 
+// NewWithGlobalsStore 使用已有的全局变量存储创建虚拟机
+// 与compiler.NewWithState配合，使REPL的多次输入共享全局变量
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = globals
+	return vm
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("frame overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == -1 {
 		return nil
@@ -51,10 +99,31 @@ func (vm *VM) LastPopped() object.Object {
 	return vm.stack[vm.sp+1]
 }
 
-func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize-1 {
+// 保证栈至少能容纳size个元素，按倍数扩大，超出StackSize时报告栈溢出
+func (vm *VM) growStack(size int) error {
+	if size > StackSize {
 		return fmt.Errorf("stack overflow")
 	}
+	if size <= len(vm.stack) {
+		return nil
+	}
+	n := len(vm.stack) * 2
+	for n < size {
+		n *= 2
+	}
+	if n > StackSize {
+		n = StackSize
+	}
+	stack := make([]object.Object, n)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) push(o object.Object) error {
+	if err := vm.growStack(vm.sp + 2); err != nil {
+		return err
+	}
 	vm.sp += 1
 	vm.stack[vm.sp] = o
 	return nil
@@ -66,83 +135,467 @@ func (vm *VM) pop() object.Object {
 	return o
 }
 
+// Run 执行字节码，出错时返回*object.Error，其中记录了出错的指令、源码位置与Monkey函数的调用栈
+// 错误被try语句捕获时继续执行对应的处理代码
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
-		switch op {
-		case code.OpConstant:
-			constIdx := binary.BigEndian.Uint16(vm.instructions[ip+1:])
-			ip += 2
-			err := vm.push(vm.constants[constIdx])
-			if err != nil {
-				return err
-			}
-		case code.OpBang:
-			if err := vm.executeBangOperator(op); err != nil {
-				return err
-			}
-		case code.OpMinus:
-			if err := vm.executeMinusOperator(op); err != nil {
-				return err
-			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			if err := vm.executeBinaryOperator(op); err != nil {
-				return err
-			}
-		case code.OpEqual, code.OpNotEqual, code.OpLess:
-			if err := vm.executeComparison(op); err != nil {
-				return err
-			}
-		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
-		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
-		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
-		case code.OpPop:
-			vm.pop()
-		case code.OpJumpNotTruthy:
-			operand := int(binary.BigEndian.Uint16(vm.instructions[ip+1:]))
-			ip += 2
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				ip = operand - 1
-			}
-		case code.OpJump:
-			operand := int(binary.BigEndian.Uint16(vm.instructions[ip+1:]))
-			ip = operand - 1
-		case code.OpSetGlobal:
-			globalIdx := int(binary.BigEndian.Uint16(vm.instructions[ip+1:]))
-			ip += 2
-			vm.globals[globalIdx] = vm.pop()
-		case code.OpGetGlobal:
-			globalIdx := int(binary.BigEndian.Uint16(vm.instructions[ip+1:]))
-			ip += 2
-			err := vm.push(vm.globals[globalIdx])
-			if err != nil {
-				return err
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+		ip := vm.currentFrame().ip
+		ins := vm.currentFrame().Instructions()
+		op := code.Opcode(ins[ip])
+
+		if err := vm.execute(op, ins, ip); err != nil {
+			rtErr := vm.runtimeError(op, ip, err)
+			if !vm.handle(rtErr) {
+				return rtErr
 			}
 		}
 	}
 	return nil
 }
 
+// 执行位于ip处的指令op，读取操作数后更新当前调用帧的ip
+func (vm *VM) execute(op code.Opcode, ins code.Instructions, ip int) error {
+	switch op {
+	case code.OpConstant:
+		constIdx := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		err := vm.push(vm.constants[constIdx])
+		if err != nil {
+			return err
+		}
+	case code.OpBang:
+		if err := vm.executeBangOperator(op); err != nil {
+			return err
+		}
+	case code.OpMinus:
+		if err := vm.executeMinusOperator(op); err != nil {
+			return err
+		}
+	case code.OpBitNot:
+		if err := vm.executeBitNotOperator(op); err != nil {
+			return err
+		}
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr:
+		if err := vm.executeBinaryOperator(op); err != nil {
+			return err
+		}
+	case code.OpEqual, code.OpNotEqual, code.OpLess, code.OpLessEqual, code.OpGreater, code.OpGreaterEqual:
+		if err := vm.executeComparison(op); err != nil {
+			return err
+		}
+	case code.OpTrue:
+		err := vm.push(True)
+		if err != nil {
+			return err
+		}
+	case code.OpFalse:
+		err := vm.push(False)
+		if err != nil {
+			return err
+		}
+	case code.OpNull:
+		err := vm.push(Null)
+		if err != nil {
+			return err
+		}
+	case code.OpPop:
+		vm.pop()
+	case code.OpJumpNotTruthy:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		condition := vm.pop()
+		if !isTruthy(condition) {
+			vm.currentFrame().ip = pos - 1
+		}
+	case code.OpJump:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip = pos - 1
+	case code.OpSetGlobal:
+		globalIdx := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		vm.globals[globalIdx] = vm.pop()
+	case code.OpGetGlobal:
+		globalIdx := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		global := vm.globals[globalIdx]
+		if global == nil {
+			// 只在REPL中出现：定义该变量的语句未能执行
+			return errors.New("identifier not found")
+		}
+		err := vm.push(global)
+		if err != nil {
+			return err
+		}
+	case code.OpArray:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		array := vm.buildArray(vm.sp+1-numElements, vm.sp+1)
+		vm.sp -= numElements
+		err := vm.push(array)
+		if err != nil {
+			return err
+		}
+	case code.OpHash:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		hash, err := vm.buildHash(vm.sp+1-numElements, vm.sp+1)
+		if err != nil {
+			return err
+		}
+		vm.sp -= numElements
+		err = vm.push(hash)
+		if err != nil {
+			return err
+		}
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+		if err := vm.executeIndexExpression(left, index); err != nil {
+			return err
+		}
+	case code.OpSetIndex:
+		val := vm.pop()
+		index := vm.pop()
+		left := vm.pop()
+		if err := vm.executeSetIndex(left, index, val); err != nil {
+			return err
+		}
+	case code.OpCall:
+		numArgs := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		if err := vm.callValue(numArgs); err != nil {
+			return err
+		}
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+		if vm.framesIndex == 1 {
+			// 顶层的return语句直接结束程序，返回值作为最后弹出的元素
+			vm.currentFrame().ip = len(ins) - 1
+			return nil
+		}
+		frame := vm.popFrame()
+		// 同时弹出局部变量与被调用的函数本身
+		vm.sp = frame.basePointer - 2
+		if err := vm.push(returnValue); err != nil {
+			return err
+		}
+	case code.OpThrow:
+		return object.Throw(vm.pop())
+	case code.OpIterable:
+		iterable := vm.stack[vm.sp]
+		if iterable.Type() != object.ARRAY_OBJ {
+			return fmt.Errorf("cannot iterate over %s", iterable.Type())
+		}
+	case code.OpReturn:
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer - 2
+		if err := vm.push(Null); err != nil {
+			return err
+		}
+	case code.OpDefineLocal:
+		localIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		vm.stack[vm.currentFrame().basePointer+localIdx] = vm.pop()
+	case code.OpSetLocal:
+		localIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		slot := &vm.stack[vm.currentFrame().basePointer+localIdx]
+		if cell, ok := (*slot).(*object.Cell); ok {
+			cell.Value = vm.pop()
+		} else {
+			*slot = vm.pop()
+		}
+	case code.OpGetLocal:
+		localIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		err := vm.push(unwrapCell(vm.stack[vm.currentFrame().basePointer+localIdx]))
+		if err != nil {
+			return err
+		}
+	case code.OpCaptureLocal:
+		localIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		slot := &vm.stack[vm.currentFrame().basePointer+localIdx]
+		if _, ok := (*slot).(*object.Cell); !ok {
+			*slot = &object.Cell{Value: *slot}
+		}
+		if err := vm.push(*slot); err != nil {
+			return err
+		}
+	case code.OpCaptureFree:
+		freeIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		free, err := vm.freeVariables(freeIdx)
+		if err != nil {
+			return err
+		}
+		if err := vm.push(free[freeIdx]); err != nil {
+			return err
+		}
+	case code.OpClosure:
+		constIdx := int(code.ReadUint16(ins[ip+1:]))
+		numFree := int(code.ReadUint8(ins[ip+3:]))
+		vm.currentFrame().ip += 3
+		if err := vm.pushClosure(constIdx, numFree); err != nil {
+			return err
+		}
+	case code.OpGetFree:
+		freeIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		free, err := vm.freeVariables(freeIdx)
+		if err != nil {
+			return err
+		}
+		if err := vm.push(unwrapCell(free[freeIdx])); err != nil {
+			return err
+		}
+	case code.OpSetFree:
+		freeIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		free, err := vm.freeVariables(freeIdx)
+		if err != nil {
+			return err
+		}
+		if cell, ok := free[freeIdx].(*object.Cell); ok {
+			cell.Value = vm.pop()
+		} else {
+			free[freeIdx] = vm.pop()
+		}
+	case code.OpCurrentClosure:
+		err := vm.push(vm.currentFrame().cl)
+		if err != nil {
+			return err
+		}
+	case code.OpGetBuiltin:
+		builtinIdx := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		err := vm.push(object.Builtins[builtinIdx].BuiltIn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 将执行指令时产生的错误转化为运行时错误，记录出错的位置与指令
+// 重新抛出的错误保留原来的位置
+func (vm *VM) runtimeError(op code.Opcode, ip int, err error) *object.Error {
+	rtErr, ok := err.(*object.Error)
+	if !ok {
+		rtErr = &object.Error{Message: err.Error()}
+	}
+	if rtErr.Position.IsValid() {
+		return rtErr
+	}
+	rtErr.Position = vm.currentFrame().cl.Fn.PositionAt(ip)
+	if def, lookupErr := code.Lookup(byte(op)); lookupErr == nil {
+		rtErr.Opcode = def.Name
+	}
+	return rtErr
+}
+
+// 由内向外在每个调用帧的异常表中查找能处理错误的项，找到时将栈恢复到try语句开始时的深度，
+// 压入被捕获的异常并跳转至处理代码。被退出的函数帧依次记录在错误的调用栈中，
+// 其位置为调用者正在执行的OpCall指令的位置
+func (vm *VM) handle(rtErr *object.Error) bool {
+	for {
+		frame := vm.currentFrame()
+		fn := frame.cl.Fn
+		if h, ok := fn.HandlerAt(frame.ip); ok {
+			vm.sp = frame.basePointer + fn.NumLocals + h.Depth - 1
+			frame.ip = h.Target - 1
+			return vm.push(&object.Exception{Err: rtErr}) == nil
+		}
+		if vm.framesIndex == 1 {
+			return false
+		}
+		vm.popFrame()
+		caller := vm.currentFrame()
+		rtErr.Frames = append(rtErr.Frames, object.Frame{
+			Function: fn.Name,
+			Call:     caller.cl.Fn.PositionAt(caller.ip),
+		})
+	}
+}
+
+// 调用位于参数之下的函数，可以是闭包或内置函数
+func (vm *VM) callValue(numArgs int) error {
+	callee := vm.stack[vm.sp-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.BuiltIn:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// 参数即为被调用函数的前几个局部变量
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	if numArgs != fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
+	}
+	basePointer := vm.sp - numArgs + 1
+	if err := vm.growStack(basePointer + fn.NumLocals + 1); err != nil {
+		return err
+	}
+	if err := vm.pushFrame(NewFrame(cl, basePointer)); err != nil {
+		return err
+	}
+	vm.sp = basePointer + fn.NumLocals - 1
+	return nil
+}
+
+// 内置函数直接在Go中执行，执行后将参数与函数本身出栈
+// 内置函数返回的错误对象转化为运行时错误，与求值器的行为一致
+func (vm *VM) callBuiltin(builtin *object.BuiltIn, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs+1 : vm.sp+1]
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+	if errObj, ok := result.(*object.Error); ok {
+		return errors.New(errObj.Message)
+	}
+	if result == nil {
+		result = Null
+	}
+	return vm.push(result)
+}
+
+// 将常量池中的函数与栈顶的numFree个自由变量组合为闭包
+func (vm *VM) pushClosure(constIdx, numFree int) error {
+	constant := vm.constants[constIdx]
+	fn, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+	if numFree > vm.sp+1 {
+		return fmt.Errorf("not enough free variables on the stack: %d", numFree)
+	}
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+1+i]
+	}
+	vm.sp -= numFree
+	return vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+// 当前闭包的自由变量，freeIdx超出范围时报告错误
+func (vm *VM) freeVariables(freeIdx int) ([]object.Object, error) {
+	free := vm.currentFrame().cl.Free
+	if freeIdx >= len(free) {
+		return nil, fmt.Errorf("free variable index out of range: %d, closure has %d", freeIdx, len(free))
+	}
+	return free, nil
+}
+
+// 使用栈中[begin, end)的元素构造数组
+func (vm *VM) buildArray(begin, end int) object.Object {
+	elements := make([]object.Object, end-begin)
+	for i := begin; i < end; i++ {
+		elements[i-begin] = vm.stack[i]
+	}
+	return &object.Array{Elements: elements}
+}
+
+// 使用栈中[begin, end)的元素构造哈希表，元素按键、值交替排列
+func (vm *VM) buildHash(begin, end int) (object.Object, error) {
+	pairs := make(map[object.HashKey]object.HashPair)
+	for i := begin; i < end; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	case left.Type() == object.EXCEPTION_OBJ && index.Type() == object.STRING_OBJ:
+		return vm.push(left.(*object.Exception).Field(index.(*object.String).Value))
+	default:
+		return fmt.Errorf("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// 越界时返回Null
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	elements := array.(*object.Array).Elements
+	i := index.(*object.Integer).Value
+	if i < 0 || i >= int64(len(elements)) {
+		return vm.push(Null)
+	}
+	return vm.push(elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObj := hash.(*object.Hash)
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+	pair, ok := hashObj.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+	return vm.push(pair.Value)
+}
+
+// 数组与哈希表被原地修改
+func (vm *VM) executeSetIndex(left, index, val object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return fmt.Errorf("index out of range: %d", i)
+		}
+		elements[i] = val
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+	default:
+		return fmt.Errorf("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+	return vm.push(val)
+}
+
 func (vm *VM) executeBinaryOperator(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperator(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperator(op, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.executeBinaryStringOperator(op, left, right)
 	}
-	return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+	return operatorError(op, left, right)
+}
+
+// 字符串仅支持拼接
+func (vm *VM) executeBinaryStringOperator(op code.Opcode, left, right object.Object) error {
+	if op != code.OpAdd {
+		return operatorError(op, left, right)
+	}
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
 func (vm *VM) executeBinaryIntegerOperator(op code.Opcode, left, right object.Object) error {
@@ -158,21 +611,80 @@ func (vm *VM) executeBinaryIntegerOperator(op code.Opcode, left, right object.Ob
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		result = leftValue % rightValue
+	case code.OpBitAnd:
+		result = leftValue & rightValue
+	case code.OpBitOr:
+		result = leftValue | rightValue
+	case code.OpBitXor:
+		result = leftValue ^ rightValue
+	case code.OpShl, code.OpShr:
+		if rightValue < 0 {
+			return fmt.Errorf("negative shift count: %d", rightValue)
+		}
+		if op == code.OpShl {
+			result = leftValue << rightValue
+		} else {
+			result = leftValue >> rightValue
+		}
+	default:
+		return operatorError(op, left, right)
 	}
 
 	return vm.push(&object.Integer{Value: result})
 }
 
+// 至少有一个操作数为浮点数，整数先转化为浮点数再运算
+func (vm *VM) executeBinaryFloatOperator(op code.Opcode, left, right object.Object) error {
+	leftValue := floatValue(left)
+	rightValue := floatValue(right)
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return operatorError(op, left, right)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
+	} else if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
 	} else if left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ {
 		return vm.executeBooleanComparison(op, left, right)
+	} else if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
 	}
-	return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	return operatorError(op, left, right)
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
@@ -186,11 +698,39 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		result = leftValue != rightValue
 	case code.OpLess:
 		result = leftValue < rightValue
+	case code.OpLessEqual:
+		result = leftValue <= rightValue
+	case code.OpGreater:
+		result = leftValue > rightValue
+	case code.OpGreaterEqual:
+		result = leftValue >= rightValue
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return operatorError(op, left, right)
 	}
-	vm.push(&object.Boolean{Value: result})
-	return nil
+	return vm.push(nativeBoolToBooleanObject(result))
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := floatValue(left)
+	rightValue := floatValue(right)
+	var result bool
+	switch op {
+	case code.OpEqual:
+		result = leftValue == rightValue
+	case code.OpNotEqual:
+		result = leftValue != rightValue
+	case code.OpLess:
+		result = leftValue < rightValue
+	case code.OpLessEqual:
+		result = leftValue <= rightValue
+	case code.OpGreater:
+		result = leftValue > rightValue
+	case code.OpGreaterEqual:
+		result = leftValue >= rightValue
+	default:
+		return operatorError(op, left, right)
+	}
+	return vm.push(nativeBoolToBooleanObject(result))
 }
 
 func (vm *VM) executeBooleanComparison(op code.Opcode, left, right object.Object) error {
@@ -203,10 +743,24 @@ func (vm *VM) executeBooleanComparison(op code.Opcode, left, right object.Object
 	case code.OpNotEqual:
 		result = leftValue != rightValue
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return operatorError(op, left, right)
 	}
-	vm.push(&object.Boolean{Value: result})
-	return nil
+	return vm.push(nativeBoolToBooleanObject(result))
+}
+
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+	var result bool
+	switch op {
+	case code.OpEqual:
+		result = leftValue == rightValue
+	case code.OpNotEqual:
+		result = leftValue != rightValue
+	default:
+		return operatorError(op, left, right)
+	}
+	return vm.push(nativeBoolToBooleanObject(result))
 }
 
 func (vm *VM) executeBangOperator(op code.Opcode) error {
@@ -216,6 +770,8 @@ func (vm *VM) executeBangOperator(op code.Opcode) error {
 		return vm.push(False)
 	case False:
 		return vm.push(True)
+	case Null:
+		return vm.push(True)
 	default:
 		return vm.push(False)
 	}
@@ -226,16 +782,84 @@ func (vm *VM) executeMinusOperator(op code.Opcode) error {
 	switch operand := operand.(type) {
 	case *object.Integer:
 		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
 	default:
-		return fmt.Errorf("unknown operator: %d (%s)", op, operand.Type())
+		return fmt.Errorf("unknown operator: - %s", operand.Type())
 	}
 }
 
+func (vm *VM) executeBitNotOperator(op code.Opcode) error {
+	operand := vm.pop()
+	integer, ok := operand.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("unknown operator: ~ %s", operand.Type())
+	}
+	return vm.push(&object.Integer{Value: ^integer.Value})
+}
+
+// 二元运算的操作码对应的运算符，用于生成与求值器一致的错误信息
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShl:          "<<",
+	code.OpShr:          ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLess:         "<",
+	code.OpLessEqual:    "<=",
+	code.OpGreater:      ">",
+	code.OpGreaterEqual: ">=",
+}
+
+// 操作数类型不同时为type mismatch，类型相同但不支持该运算时为unknown operator
+func operatorError(op code.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), infixOperators[op], right.Type())
+	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), infixOperators[op], right.Type())
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func floatValue(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
+// 返回静态的True/False对象，使得executeBangOperator中的指针比较成立
+func nativeBoolToBooleanObject(b bool) *object.Boolean {
+	if b {
+		return True
+	}
+	return False
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
 		return obj.Value
+	case *object.Null:
+		return false
 	default:
 		return true
 	}
 }
+
+// 被闭包捕获的变量存放在共享单元中，读取时取出其中的值
+func unwrapCell(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
+	return obj
+}
@@ -1,12 +1,16 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"monkey_cc/ast"
+	"monkey_cc/code"
 	"monkey_cc/compiler"
 	"monkey_cc/lexer"
 	"monkey_cc/object"
 	"monkey_cc/parser"
+	"monkey_cc/token"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +40,17 @@ func testIntegerObject(val object.Object, expected int64) error {
 	return nil
 }
 
+func testFloatObject(val object.Object, expected float64) error {
+	result, ok := val.(*object.Float)
+	if !ok {
+		return fmt.Errorf(NOT_EXPECTED, "val.(type)", "*object.Float", val.Type())
+	}
+	if result.Value != expected {
+		return fmt.Errorf(NOT_EXPECTED, "result.Value", expected, result.Value)
+	}
+	return nil
+}
+
 func testBooleanObject(val object.Object, expected bool) error {
 	result, ok := val.(*object.Boolean)
 	if !ok {
@@ -47,6 +62,17 @@ func testBooleanObject(val object.Object, expected bool) error {
 	return nil
 }
 
+func testStringObject(val object.Object, expected string) error {
+	result, ok := val.(*object.String)
+	if !ok {
+		return fmt.Errorf(NOT_EXPECTED, "val.(type)", "*object.String", val.Type())
+	}
+	if result.Value != expected {
+		return fmt.Errorf(NOT_EXPECTED, "result.Value", expected, result.Value)
+	}
+	return nil
+}
+
 func testObject(t *testing.T, expected interface{}, val object.Object) {
 	t.Helper()
 	switch expected := expected.(type) {
@@ -55,11 +81,58 @@ func testObject(t *testing.T, expected interface{}, val object.Object) {
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(val, expected)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(val, bool(expected))
 		if err != nil {
 			t.Errorf("testBooleanObject failed: %s", err)
 		}
+	case string:
+		err := testStringObject(val, expected)
+		if err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+	case []int:
+		array, ok := val.(*object.Array)
+		if !ok {
+			t.Errorf(NOT_EXPECTED, "val.(type)", "*object.Array", val.Type())
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf(WRONG_LENGTH, "array.Elements", len(expected), len(array.Elements))
+			return
+		}
+		for i, el := range expected {
+			err := testIntegerObject(array.Elements[i], int64(el))
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case map[object.HashKey]int64:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			t.Errorf(NOT_EXPECTED, "val.(type)", "*object.Hash", val.Type())
+			return
+		}
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf(WRONG_LENGTH, "hash.Pairs", len(expected), len(hash.Pairs))
+			return
+		}
+		for key, value := range expected {
+			pair, ok := hash.Pairs[key]
+			if !ok {
+				t.Errorf("no pair for given key in hash.Pairs")
+				continue
+			}
+			err := testIntegerObject(pair.Value, value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
 	case *object.Null:
 		if val != Null {
 			t.Errorf("object is not Null: %T (%+v)", val, val)
@@ -91,6 +164,33 @@ func runTests(t *testing.T, tests []vmTest) {
 	}
 }
 
+// VM运行出错时，比较错误信息
+type vmErrorTest struct {
+	input    string
+	expected string
+}
+
+func runErrorTests(t *testing.T, tests []vmErrorTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf(COMPILER_ERROR, err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf(NOT_EXPECTED, "err.Error()", tt.expected, err.Error())
+		}
+	}
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTest{
 		{"1", 1},
@@ -102,6 +202,31 @@ func TestIntegerArithmetic(t *testing.T) {
 	runTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTest{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999.0},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+	}
+	runTests(t, tests)
+
+	errorTests := []vmErrorTest{
+		{"1.5 / 0", "division by zero"},
+		{"1.5 & 1", "type mismatch: FLOAT & INTEGER"},
+		{"1.5 | 2.5", "unknown operator: FLOAT | FLOAT"},
+		{`1.5 + "a"`, "type mismatch: FLOAT + STRING"},
+	}
+	runErrorTests(t, errorTests)
+}
+
 func TestBooleanExp(t *testing.T) {
 	tests := []vmTest{
 		{"true", true},
@@ -133,6 +258,101 @@ func TestIfExp(t *testing.T) {
 	runTests(t, tests)
 }
 
+func TestLoops(t *testing.T) {
+	tests := []vmTest{
+		{"while (true) { break; } 5", 5},
+		{"let last = 0; for (x in [1, 2, 3]) { last = x; if (x == 3) { break; } } last", 3},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x > 1) { return x * 10; } } }; f()", 20},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x < 3) { continue; } return x; } }; f()", 3},
+		{"let f = fn() { while (true) { if (true) { return 7; } } }; f()", 7},
+		{"let f = fn() { for (x in []) { return 1; } }; f()", Null},
+		{"let f = fn() { for (x in [1, 2]) { let last = 0; for (y in [3, 4]) { last = y; break; } if (x == 2) { return last; } } }; f()", 3},
+		// 循环中的闭包捕获循环变量
+		{"let f = fn(a) { for (x in a) { if (x > 1) { return fn() { x }; } } }; f([1, 2, 3])()", 2},
+		// 表达式中的break与continue弹出表达式已压栈的值，反复执行也不会使栈溢出
+		{"let i = 0; while (i < 5000) { i = i + 1; let y = [1, if (true) { continue; }]; } i", 5000},
+		{"let f = fn() { let i = 0; while (i < 5000) { i += 1; 1 + [2, 3, if (true) { continue; }][0]; } i }; f()", 5000},
+		{"let n = 0; while (n < 3) { n += 1; let y = if (true) { break; }; } n", 1},
+		{"let s = 0; for (x in [1, 2, 3]) { s += [x, if (x == 2) { break; } else { 0 }][0]; } s", 1},
+	}
+	runTests(t, tests)
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []vmErrorTest{
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{`for (x in "ab") { x }`, "cannot iterate over STRING"},
+	}
+	runErrorTests(t, tests)
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []vmTest{
+		{`let r = 0; try { throw "a"; r = 1; } catch (e) { r = e["message"]; } r`, "a"},
+		{`let r = 0; try { 1 + true } catch (e) { r = e["type"]; } r`, "RuntimeError"},
+		{`let r = 0; try { r = 1 } finally { r += 1 } r`, 2},
+		{"let f = fn() { try { return 1; } finally { return 2; } }; f()", 2},
+		{"let n = 0; while (n < 3) { try { n += 1; continue; } finally { n += 10; } } n", 11},
+		// 异常跨越函数调用，处理代码在捕获异常的函数中继续执行
+		{"let f = fn(x) { if (x > 1) { throw x } x }; let g = fn() { let a = 1; try { a = f(2) } catch (e) { a = a + e[\"value\"] } a }; g()", 3},
+		// 处理代码将栈恢复到try语句开始时的深度
+		{"[1, if (true) { try { throw 2 } catch (e) { } 3 }]", []int{1, 3}},
+	}
+	runTests(t, tests)
+
+	errorTests := []vmErrorTest{
+		{`throw "a"`, "a"},
+		{`try { throw "a" } finally { 1 }`, "a"},
+		{`try { throw "a" } catch (e) { throw e["message"] + "b" }`, "ab"},
+	}
+	runErrorTests(t, errorTests)
+}
+
+func TestAssignExp(t *testing.T) {
+	tests := []vmTest{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 2; x -= 3; x *= 4; x /= 6; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; let s = 0; while (i < 5) { i += 1; s += i; } s", 15},
+		{"let x = 1; let f = fn() { x = 2; }; f(); x", 2},
+		{"let f = fn() { let t = 0; for (x in [1, 2, 3]) { t += x; } t }; f()", 6},
+		// 闭包修改自身捕获的自由变量
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next()", 2},
+		// 闭包与定义变量的函数共享被捕获的变量
+		{"let mk = fn() { let c = 0; let inc = fn() { c += 1; c }; inc(); inc(); c }; mk()", 2},
+		{"let mk = fn() { let n = 0; let inc = fn() { n += 1 }; let get = fn() { n }; inc(); inc(); get() }; mk()", 2},
+		{"let f = fn() { let f = fn() { f = 5; 0 }; f(); f }; f()", 5},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[2] *= 2; a[2]", 6},
+		{"let a = [1, 2]; let b = a; b[0] = 9; a[0]", 9},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 5; h["a"] + h["b"]`, 7},
+	}
+	runTests(t, tests)
+}
+
+func TestAssignExpErrors(t *testing.T) {
+	tests := []vmErrorTest{
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{`let s = "ab"; s[0] = "c"`, "index assignment not supported: STRING[INTEGER]"},
+		{"let h = {}; h[fn() {}] = 1", "unusable as hash key: FUNCTION"},
+	}
+	runErrorTests(t, tests)
+}
+
+func TestBlockScope(t *testing.T) {
+	tests := []vmTest{
+		{"let x = 1; if (true) { let x = 2; x }", 2},
+		{"let x = 1; if (true) { let x = 2; } x", 1},
+		{"let x = 1; if (true) { x = 2; } x", 2},
+		{"let f = fn(x) { if (x > 0) { let x = x * 10; return x; } x }; f(1) + f(-1)", 9},
+		{"let f = fn() { let a = 1; if (true) { let b = 2; fn() { a + b } } }; f()()", 3},
+		{"let f = fn() { let g = 0; for (x in [1, 2]) { if (x == 1) { g = fn() { x }; } } g() }; f()", 1},
+		{"const x = [1, 2]; let s = 0; for (x in x) { s += x; } s", 3},
+	}
+	runTests(t, tests)
+}
+
 func TestGlobalLetStmt(t *testing.T) {
 	tests := []vmTest{
 		{"let one = 1; one", 1},
@@ -140,3 +360,371 @@ func TestGlobalLetStmt(t *testing.T) {
 	}
 	runTests(t, tests)
 }
+
+// REPL中定义语句未能执行时，全局变量已在符号表中定义却从未写入
+func TestUndefinedGlobal(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.Define("x")
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(parse("x + 1"))
+	if err != nil {
+		t.Fatalf(COMPILER_ERROR, err)
+	}
+	vm := NewWithGlobalsStore(comp.Bytecode(), make([]object.Object, GlobalSize))
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none")
+	}
+	if err.Error() != "identifier not found" {
+		t.Errorf(NOT_EXPECTED, "err.Error()", "identifier not found", err.Error())
+	}
+}
+
+// 自由变量的索引超出闭包捕获的变量个数时报告错误而不是越界访问
+func TestFreeIndexOutOfRange(t *testing.T) {
+	concatInstructions := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	fn := &object.CompiledFunction{
+		Instructions: concatInstructions(
+			code.Make(code.OpGetFree, 3),
+			code.Make(code.OpReturnValue),
+		),
+	}
+	bytecode := &compiler.Bytecode{
+		Instructions: concatInstructions(
+			code.Make(code.OpClosure, 0, 0),
+			code.Make(code.OpCall, 0),
+			code.Make(code.OpPop),
+		),
+		Constants: []object.Object{fn},
+	}
+	err := New(bytecode).Run()
+	expect := "free variable index out of range: 3, closure has 0"
+	if err == nil || err.Error() != expect {
+		t.Errorf(NOT_EXPECTED, "err", expect, err)
+	}
+}
+
+func TestStringExp(t *testing.T) {
+	tests := []vmTest{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"Hello, " + "world!"`, "Hello, world!"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"monkey" == "monkey"`, true},
+		{`"monkey" == "banana"`, false},
+		{`"monkey" != "banana"`, true},
+		{`!("a" == "a")`, false},
+		{`let s = "mon"; s + "key"`, "monkey"},
+		// len返回字符串的字节数
+		{`len("mon" + "key")`, 6},
+		{`len("")`, 0},
+		{`len("\u{1F600}")`, 4},
+		{`let s = "ab"; len(s + s) == 4`, true},
+	}
+	runTests(t, tests)
+}
+
+func TestArrayLiteral(t *testing.T) {
+	tests := []vmTest{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+	runTests(t, tests)
+}
+
+func TestHashLiteral(t *testing.T) {
+	tests := []vmTest{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			"{1: 2, 2: 3}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 2,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+			},
+		},
+		{
+			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 2}).HashKey(): 4,
+				(&object.Integer{Value: 6}).HashKey(): 16,
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestIndexExp(t *testing.T) {
+	tests := []vmTest{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][0 + 2]", 3},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+		{`{"one": 1}["o" + "ne"]`, 1},
+	}
+	runTests(t, tests)
+}
+
+func TestIndexExpErrors(t *testing.T) {
+	tests := []vmErrorTest{
+		{"{[1]: 1}", "unusable as hash key: ARRAY"},
+		{"{1: 1}[[1]]", "unusable as hash key: ARRAY"},
+		{"1[0]", "index operator not supported: INTEGER[INTEGER]"},
+	}
+	runErrorTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTest{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", 3},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c();", 3},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let returnsOne = fn() { 1; }; let returnsOneReturner = fn() { returnsOne; }; returnsOneReturner()();", 1},
+		{"let one = fn() { let one = 1; one }; one();", 1},
+		{"let oneAndTwo = fn() { let one = 1; let two = 2; one + two; }; oneAndTwo();", 3},
+		{"let globalSeed = 50; let minusOne = fn() { let num = 1; globalSeed - num; }; minusOne();", 49},
+		{"let identity = fn(a) { a; }; identity(4);", 4},
+		{"let sum = fn(a, b) { a + b; }; sum(1, 2);", 3},
+		{"let sum = fn(a, b) { let c = a + b; c; }; let outer = fn() { sum(1, 2) + sum(3, 4); }; outer();", 10},
+		{"fn(x) { x; }(5)", 5},
+		{"return 10; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } } return 1;", 10},
+		{"if (true) { let a = 1; }", Null},
+	}
+	runTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmErrorTest{
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"fn(a) { a; }();", "wrong number of arguments: want=1, got=0"},
+		{"fn(a, b) { a + b; }(1);", "wrong number of arguments: want=2, got=1"},
+		{"1();", "not a function: INTEGER"},
+	}
+	runErrorTests(t, tests)
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	input := "let add = fn(a, b) { a + b };\nlet call = fn() { add(1, true) };\ncall();"
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf(COMPILER_ERROR, err)
+	}
+	var errObj *object.Error
+	if !errors.As(New(comp.Bytecode()).Run(), &errObj) {
+		t.Fatalf("expected *object.Error")
+	}
+	if errObj.Opcode != "OpAdd" {
+		t.Errorf(NOT_EXPECTED, "Opcode", "OpAdd", errObj.Opcode)
+	}
+	if errObj.Position.String() != "1:24" {
+		t.Errorf(NOT_EXPECTED, "Position", "1:24", errObj.Position)
+	}
+	expected := []object.Frame{
+		{Function: "add", Call: token.Position{Offset: 51, Line: 2, Column: 22}},
+		{Function: "call", Call: token.Position{Offset: 68, Line: 3, Column: 5}},
+	}
+	if len(errObj.Frames) != len(expected) {
+		t.Fatalf(WRONG_LENGTH, "Frames", len(expected), len(errObj.Frames))
+	}
+	for i, frame := range expected {
+		if errObj.Frames[i] != frame {
+			t.Errorf(NOT_EXPECTED, "frame", frame, errObj.Frames[i])
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTest{
+		{"let newClosure = fn(a) { fn() { a; }; }; let closure = newClosure(99); closure();", 99},
+		{"let newAdder = fn(a, b) { fn(c) { a + b + c }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{"let newAdder = fn(a, b) { let c = a + b; fn(d) { c + d }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{`
+		let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) {
+				let e = d + c;
+				fn(f) { e + f; };
+			};
+		};
+		let newAdderInner = newAdderOuter(1, 2);
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+		{`
+		let a = 1;
+		let newAdderOuter = fn(b) {
+			fn(c) {
+				fn(d) { a + b + c + d };
+			};
+		};
+		let newAdderInner = newAdderOuter(2);
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+	}
+	runTests(t, tests)
+}
+
+func TestRecursiveClosures(t *testing.T) {
+	tests := []vmTest{
+		{`
+		let countDown = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				countDown(x - 1);
+			}
+		};
+		countDown(1);`, 0},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+		};
+		wrapper();`, 0},
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				if (x == 1) {
+					return 1;
+				} else {
+					fibonacci(x - 1) + fibonacci(x - 2);
+				}
+			}
+		};
+		fibonacci(15);`, 610},
+	}
+	runTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTest{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`let f = fn(s) { len(s) }; f("monkey")`, 6},
+		{`let len = fn(s) { 42 }; len("monkey")`, 42},
+		{`int(3.9)`, 3},
+		{`int(-3.9)`, -3},
+		{`int("42")`, 42},
+		{`int(7)`, 7},
+		{`float(2)`, 2.0},
+		{`float("1.25")`, 1.25},
+		{`float(0.5)`, 0.5},
+	}
+	runTests(t, tests)
+}
+
+func TestStackOverflowTrace(t *testing.T) {
+	input := "let f = fn(n) {\n  f(n + 1)\n};\nf(0);"
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf(COMPILER_ERROR, err)
+	}
+	var errObj *object.Error
+	if !errors.As(New(comp.Bytecode()).Run(), &errObj) {
+		t.Fatalf("expected *object.Error")
+	}
+	// 递归产生的上千个相同的调用被合并为一行
+	trace := strings.Split(errObj.StackTrace(), "\n")
+	if len(trace) > 5 {
+		t.Fatalf("expected a collapsed stack trace, found %d lines", len(trace))
+	}
+	more := fmt.Sprintf("    ... %d more", len(errObj.Frames)-len(trace)+3)
+	if trace[len(trace)-2] != more {
+		t.Errorf(NOT_EXPECTED, "collapsed frames", more, trace[len(trace)-2])
+	}
+	if trace[len(trace)-1] != "    at <main> (4:2)" {
+		t.Errorf(NOT_EXPECTED, "outermost frame", "    at <main> (4:2)", trace[len(trace)-1])
+	}
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmErrorTest{
+		{`len(1)`, "argument type INTEGER to `len` is not supported"},
+		{`len("one", "two")`, "wrong number of arguments, expect: 1, found: 2."},
+		{`int("4.5")`, `cannot convert "4.5" to INTEGER`},
+		{`int(1e19)`, "cannot convert 1e+19 to INTEGER"},
+		{`float("x")`, `cannot convert "x" to FLOAT`},
+		{`float(true)`, "argument type BOOLEAN to `float` is not supported"},
+	}
+	runErrorTests(t, tests)
+}
+
+func TestLogicalExp(t *testing.T) {
+	tests := []vmTest{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"if (1 > 2) { 1 } || 5", true},
+		{"if (1 > 2) { 1 } && 5", false},
+		{"!(if (1 > 2) { 1 })", true},
+		// 右值不会被求值，否则会产生运行时错误
+		{"false && len(1)", false},
+		{"true || len(1)", true},
+		{"let f = fn(x) { x > 0 && x < 10 }; f(5)", true},
+	}
+	runTests(t, tests)
+}
+
+func TestBitwiseExp(t *testing.T) {
+	tests := []vmTest{
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"1 | 2 & 3", 3},
+		{"(1 | 2) & 2", 2},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 | 6 ^ 3 & 5", 7},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7.5 % 2", 1.5},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{"2 <= 1.5", false},
+	}
+	runTests(t, tests)
+
+	errorTests := []vmErrorTest{
+		{"1 % 0", "modulo by zero"},
+		{"1.5 % 0", "modulo by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"~true", "unknown operator: ~ BOOLEAN"},
+		{"1.5 ^ 1", "type mismatch: FLOAT ^ INTEGER"},
+		{`"a" <= "b"`, "unknown operator: STRING <= STRING"},
+	}
+	runErrorTests(t, errorTests)
+}